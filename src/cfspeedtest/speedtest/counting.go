@@ -0,0 +1,22 @@
+package speedtest
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// CountingReader wraps R and adds each Read's returned byte count to
+// Counter, so a worker's cumulative bytes transferred can be tracked
+// without instrumenting every Read call site itself.
+type CountingReader struct {
+	R       io.Reader
+	Counter *atomic.Int64
+}
+
+func (r *CountingReader) Read(p []byte) (int, error) {
+	n, err := r.R.Read(p)
+	if n > 0 {
+		r.Counter.Add(int64(n))
+	}
+	return n, err
+}
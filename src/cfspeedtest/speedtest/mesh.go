@@ -0,0 +1,21 @@
+package speedtest
+
+// MeshResult holds the matrices from a full N×N mesh measurement: Mbps[i][j]
+// is the throughput achieved downloading from Servers[i] while LatencyMs[i][j]
+// is the loaded latency observed against Servers[j] during that same
+// download. Off-diagonal cells surface the bufferbloat one server's traffic
+// induces on the path to another.
+type MeshResult struct {
+	Servers   []string    `json:"servers"`
+	Mbps      [][]float64 `json:"mbps"`
+	LatencyMs [][]float64 `json:"latencyMs"`
+	Summary   MeshSummary `json:"summary"`
+}
+
+// MeshSummary reduces a MeshResult's matrices to the headline numbers most
+// likely to flag a problem server or path in a larger mesh.
+type MeshSummary struct {
+	MeanMbps     float64 `json:"meanMbps"`
+	MinMbps      float64 `json:"minMbps"`
+	MaxLatencyMs float64 `json:"maxLatencyMs"`
+}
@@ -0,0 +1,15 @@
+package speedtest
+
+// ProbeResult holds transport-level RTT and packet-loss statistics from an
+// ICMP or UDP echo probe, as a loss-aware complement to LatencyResult's
+// HTTP-based timing.
+type ProbeResult struct {
+	Mode     string  `json:"mode"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	LossPct  float64 `json:"lossPct"`
+	MinMs    float64 `json:"minMs"`
+	MedianMs float64 `json:"medianMs"`
+	P95Ms    float64 `json:"p95Ms"`
+	MaxMs    float64 `json:"maxMs"`
+}
@@ -0,0 +1,37 @@
+package speedtest
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// SampleInterval is how often a throughput measurement samples cumulative
+// bytes to compute an instantaneous rate, both for ProgressSample output
+// and for the steady-state mean at the end of the run.
+const SampleInterval = 1 * time.Second
+
+// ProgressSample is one periodic snapshot streamed out of a throughput
+// measurement, as a keepalive and live-monitoring signal during long runs.
+type ProgressSample struct {
+	ElapsedSecs     float64 `json:"elapsedSecs"`
+	Mbps            float64 `json:"mbps"`
+	CumulativeBytes int64   `json:"cumulativeBytes"`
+	ActiveWorkers   int32   `json:"activeWorkers"`
+	LoadedLatencyMs float64 `json:"loadedLatencyMs,omitempty"`
+}
+
+// WriteProgressSample JSON-encodes sample as a single line to w. A nil w is
+// a no-op, and encode errors are swallowed: a broken progress sink shouldn't
+// fail the measurement it's reporting on.
+func WriteProgressSample(w io.Writer, sample ProgressSample) {
+	if w == nil {
+		return
+	}
+	b, err := json.Marshal(sample)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	w.Write(b)
+}
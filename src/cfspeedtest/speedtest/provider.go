@@ -0,0 +1,78 @@
+package speedtest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Direction selects the traffic pattern MeasureThroughput drives: a single
+// direction, or both at once to measure how they contend for the same link.
+type Direction int
+
+const (
+	Download Direction = iota
+	Upload
+	Bidirectional
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Download:
+		return "download"
+	case Upload:
+		return "upload"
+	case Bidirectional:
+		return "bidirectional"
+	default:
+		return "unknown"
+	}
+}
+
+// Provider abstracts a speedtest backend's discovery, selection, and
+// measurement pipeline so main.run can drive any backend (UWN, Ookla, ...)
+// without depending on its wire protocol.
+type Provider interface {
+	// Name identifies the provider for logging and the -provider flag.
+	Name() string
+
+	// FetchToken acquires whatever per-session credential the backend
+	// requires before hitting its server/ping/throughput endpoints. Backends
+	// that need none (e.g. Ookla) return an empty string.
+	FetchToken(ctx context.Context, client *http.Client) (string, error)
+
+	// DiscoverServers lists candidate servers/endpoints for this backend.
+	DiscoverServers(ctx context.Context, client *http.Client) ([]Server, error)
+
+	// SelectServers narrows candidates to the best count servers for the
+	// client's location, using whatever distance/latency heuristic the
+	// backend implements. When family is "v4" or "v6", candidates without a
+	// resolvable address in that family are dropped first. ifaceName is
+	// passed through for backends that factor destination address selection
+	// (RFC 6724) into ranking; backends that don't may ignore it.
+	SelectServers(ctx context.Context, client *http.Client, token string, candidates []Server, count int, clientLat, clientLon float64, family, ifaceName string) ([]Server, error)
+
+	// MeasureLatency measures unloaded RTT/jitter against server.
+	MeasureLatency(ctx context.Context, client *http.Client, server Server, token string) (*LatencyResult, error)
+
+	// MeasureThroughput drives traffic in direction across servers using
+	// streams concurrent workers for duration. When direction is
+	// Bidirectional, workers are split between download and upload so both
+	// run concurrently over the same transport, and the result's DownBps/
+	// UpBps report each direction's independent throughput alongside Bps (the
+	// combined total). proxyURL is a socks5://, http://, or https:// URL
+	// (empty to use environment proxy settings or none). family forces "v4"
+	// or "v6" dialing ("" / "auto" lets the OS pick). When autoTune is set,
+	// backends that support it ramp concurrency up from a small starting
+	// point instead of launching streams workers immediately, treating
+	// streams as a ramp cap rather than a fixed count; backends that don't
+	// support it may ignore the flag and use streams directly. When progress
+	// is non-nil, backends that support it stream a ProgressSample JSON line
+	// to it roughly once per SampleInterval; backends that don't support it
+	// may ignore it. When maxMbps is > 0, backends that support it cap the
+	// aggregate offered load (shared across streams) to that many Mbps
+	// instead of measuring at max saturation; backends that don't support it
+	// may ignore it.
+	MeasureThroughput(ctx context.Context, direction Direction, streams int, duration time.Duration, ifaceName, proxyURL, family string, autoTune bool, progress io.Writer, maxMbps float64, servers []Server, token string) (*ThroughputResult, error)
+}
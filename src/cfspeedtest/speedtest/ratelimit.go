@@ -0,0 +1,47 @@
+package speedtest
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitedReader wraps R and blocks each Read's returned bytes through
+// Limiter before returning, so the caller's effective read rate never
+// exceeds Limiter's configured rate regardless of how fast R can produce
+// data. A nil Limiter disables shaping, making this a transparent pass-
+// through. Used to cap offered load on both the download (R reads the
+// response body) and upload (R reads the request body) legs of a throughput
+// measurement; sharing one Limiter across workers keeps the aggregate rate
+// capped regardless of how many streams are running.
+type RateLimitedReader struct {
+	R       io.Reader
+	Limiter *rate.Limiter
+	Ctx     context.Context
+}
+
+func (r *RateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.R.Read(p)
+	if n > 0 && r.Limiter != nil {
+		if waitErr := r.Limiter.WaitN(r.Ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// NewRateLimiter builds a shared token-bucket limiter for maxMbps (megabits
+// per second), sized so a single buffer-sized Read/Write never exceeds the
+// bucket's burst. maxMbps <= 0 means unlimited, reported as a nil Limiter.
+func NewRateLimiter(maxMbps float64) *rate.Limiter {
+	if maxMbps <= 0 {
+		return nil
+	}
+	bytesPerSec := maxMbps * 1_000_000 / 8
+	burst := int(bytesPerSec)
+	if burst < ReadBufferSize {
+		burst = ReadBufferSize
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+}
@@ -0,0 +1,99 @@
+package speedtest
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrKind classifies a throughput-loop request failure as transient (worth
+// a bounded backoff-and-retry) or fatal (no retry will help; the caller
+// should stop every worker and surface a typed error instead).
+type ErrKind int
+
+const (
+	ErrTransient ErrKind = iota
+	ErrFatal
+)
+
+// ClassifyError reports whether err (from an HTTP round trip) or a non-2xx
+// statusCode (0 if the request never got a response) is fatal: the
+// interface disappearing out from under the dialer, a TLS handshake being
+// refused, or a 507 (insufficient storage) from the server all mean
+// retrying won't help. Everything else — timeouts, connection resets,
+// ordinary 4xx/5xx — is transient and left to the caller's backoff
+// schedule.
+func ClassifyError(err error, statusCode int) ErrKind {
+	if statusCode == http.StatusInsufficientStorage {
+		return ErrFatal
+	}
+	if err == nil {
+		return ErrTransient
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		var errno syscall.Errno
+		if errors.As(opErr.Err, &errno) {
+			switch errno {
+			case syscall.EADDRNOTAVAIL, syscall.ENETUNREACH, syscall.ENETDOWN, syscall.ENODEV:
+				return ErrFatal
+			}
+		}
+	}
+
+	var tlsErr *tls.RecordHeaderError
+	if errors.As(err, &tlsErr) {
+		return ErrFatal
+	}
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return ErrFatal
+	}
+
+	return ErrTransient
+}
+
+const (
+	retryBackoffFloor = 50 * time.Millisecond
+	retryBackoffCap   = 2 * time.Second
+)
+
+// RetryBackoff returns the delay before retrying the attempt-th (0-indexed)
+// consecutive transient failure, doubling from retryBackoffFloor up to
+// retryBackoffCap instead of a flat sleep, so a flapping server doesn't get
+// hammered at full request rate.
+func RetryBackoff(attempt int) time.Duration {
+	if attempt < 0 {
+		attempt = 0
+	}
+	if attempt > 6 { // 50ms * 2^6 = 3.2s already exceeds the cap
+		return retryBackoffCap
+	}
+	d := retryBackoffFloor * time.Duration(uint(1)<<uint(attempt))
+	if d > retryBackoffCap {
+		return retryBackoffCap
+	}
+	return d
+}
+
+// FatalThroughputError marks a throughput-loop failure that retrying won't
+// fix, as classified by ClassifyError or by a request-level policy (e.g.
+// repeated auth failures). Reason is a short human-readable cause; Err is
+// the underlying error, if any.
+type FatalThroughputError struct {
+	Reason string
+	Err    error
+}
+
+func (e *FatalThroughputError) Error() string {
+	if e.Err != nil {
+		return e.Reason + ": " + e.Err.Error()
+	}
+	return e.Reason
+}
+
+func (e *FatalThroughputError) Unwrap() error { return e.Err }
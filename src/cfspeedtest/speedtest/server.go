@@ -0,0 +1,30 @@
+package speedtest
+
+// Server describes a candidate speedtest server/endpoint, in a shape shared
+// across provider backends (UWN, Ookla, ...) so discovery, selection, and
+// measurement code can be written against one type regardless of which
+// backend's wire protocol produced it.
+type Server struct {
+	URL         string  `json:"url"`
+	Provider    string  `json:"provider"`
+	City        string  `json:"city"`
+	Country     string  `json:"country"`
+	CountryCode string  `json:"countryCode,omitempty"`
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+
+	// Set after latency probing
+	LatencyMs float64 `json:"-"`
+
+	// Set by SelectServers' scoring pass, for JSON debugging of why a
+	// candidate was (or wasn't) picked. DistScore/RTTScore/JitterScore/
+	// HandshakeScore are each normalized 0..1 over the probed pool; Score is
+	// their weighted sum (lower is better) per the selection weights in use.
+	JitterMs       float64 `json:"jitterMs,omitempty"`
+	HandshakeMs    float64 `json:"handshakeMs,omitempty"`
+	DistScore      float64 `json:"distScore,omitempty"`
+	RTTScore       float64 `json:"rttScore,omitempty"`
+	JitterScore    float64 `json:"jitterScore,omitempty"`
+	HandshakeScore float64 `json:"handshakeScore,omitempty"`
+	Score          float64 `json:"score,omitempty"`
+}
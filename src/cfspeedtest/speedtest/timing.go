@@ -0,0 +1,114 @@
+package speedtest
+
+import (
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// ProbeInterval is the cadence of the loaded-latency probe that runs
+// alongside a throughput measurement (and each mesh edge), independent of
+// SampleInterval's throughput-sampling cadence.
+const ProbeInterval = 500 * time.Millisecond
+
+// TTFBReader wraps a response body and stamps *TTFB with the elapsed time
+// from Start to the first non-empty Read — i.e. the stream's
+// time-to-first-byte. Only the first such Read is recorded.
+type TTFBReader struct {
+	R       io.Reader
+	Start   time.Time
+	TTFB    *time.Duration
+	stamped bool
+}
+
+func (r *TTFBReader) Read(p []byte) (int, error) {
+	n, err := r.R.Read(p)
+	if n > 0 && !r.stamped {
+		*r.TTFB = time.Since(r.Start)
+		r.stamped = true
+	}
+	return n, err
+}
+
+// DurationStats summarizes a distribution of per-request timings (e.g.
+// upload/download response times, download time-to-first-byte), in the same
+// ms-float JSON shape as ProbeResult's latency fields.
+type DurationStats struct {
+	AvgMs  float64 `json:"avgMs"`
+	P50Ms  float64 `json:"p50Ms"`
+	P90Ms  float64 `json:"p90Ms"`
+	P99Ms  float64 `json:"p99Ms"`
+	P999Ms float64 `json:"p999Ms"`
+}
+
+// ComputeDurationStats computes avg/p50/p90/p99/p999 over samples using
+// linear interpolation between the two closest ranks. Returns the zero value if samples is empty.
+func ComputeDurationStats(samples []time.Duration) DurationStats {
+	if len(samples) == 0 {
+		return DurationStats{}
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, v := range sorted {
+		sum += v
+	}
+	avg := sum / time.Duration(len(sorted))
+
+	return DurationStats{
+		AvgMs:  avg.Seconds() * 1000,
+		P50Ms:  durationPercentileMs(sorted, 0.50),
+		P90Ms:  durationPercentileMs(sorted, 0.90),
+		P99Ms:  durationPercentileMs(sorted, 0.99),
+		P999Ms: durationPercentileMs(sorted, 0.999),
+	}
+}
+
+// ComputeLatencyStats returns the median and mean-absolute-successive-
+// difference jitter of samples (in ms) — the same algorithm MeasureLatency
+// uses for unloaded RTT, factored out here for loaded-latency callers
+// (MeasureThroughput's sampling loop, mesh edges).
+func ComputeLatencyStats(samples []float64) (medianMs, jitterMs float64) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 0 {
+		medianMs = (sorted[n/2-1] + sorted[n/2]) / 2.0
+	} else {
+		medianMs = sorted[n/2]
+	}
+
+	if n >= 2 {
+		var sum float64
+		for i := 1; i < n; i++ {
+			sum += math.Abs(sorted[i] - sorted[i-1])
+		}
+		jitterMs = sum / float64(n-1)
+	}
+	return medianMs, jitterMs
+}
+
+// durationPercentileMs returns the p-th percentile (0..1) of pre-sorted
+// durations, in milliseconds, using linear interpolation between the two
+// closest ranks.
+func durationPercentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0].Seconds() * 1000
+	}
+	idx := p * float64(len(sorted)-1)
+	lo := int(math.Floor(idx))
+	hi := int(math.Ceil(idx))
+	loMs := sorted[lo].Seconds() * 1000
+	if lo == hi {
+		return loMs
+	}
+	hiMs := sorted[hi].Seconds() * 1000
+	frac := idx - float64(lo)
+	return loMs*(1-frac) + hiMs*frac
+}
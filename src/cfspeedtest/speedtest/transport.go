@@ -1,40 +1,57 @@
 package speedtest
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
+
+	"golang.org/x/net/proxy"
 )
 
+// ReadBufferSize is the buffer size used when draining a throughput
+// response body, matching NewThroughputTransport's HTTP read/write buffer
+// size.
+const ReadBufferSize = 256 << 10 // 256 KB
+
 // NewTransport creates an HTTP transport that forces HTTP/1.1 (separate TCP
-// connections per worker) and optionally binds to a specific network interface.
-func NewTransport(ifaceName string) (*http.Transport, error) {
+// connections per worker) and optionally binds to a specific network interface
+// and/or routes through a SOCKS5/HTTP(S) proxy per cfg.Proxy.
+func NewTransport(cfg Config) (*http.Transport, error) {
 	t := &http.Transport{
 		ForceAttemptHTTP2:   false,
 		MaxIdleConnsPerHost: 1,
-		TLSNextProto:       make(map[string]func(string, *tls.Conn) http.RoundTripper),
+		TLSNextProto:        make(map[string]func(string, *tls.Conn) http.RoundTripper),
 	}
 
-	if ifaceName != "" {
-		localAddr, err := ResolveInterfaceAddr(ifaceName)
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if cfg.Interface != "" {
+		localAddr, err := ResolveInterfaceAddrFamily(cfg.Interface, cfg.AddressFamily)
 		if err != nil {
 			return nil, err
 		}
-		dialer := &net.Dialer{
-			LocalAddr: localAddr,
-			Timeout:   30 * time.Second,
-		}
-		t.DialContext = dialer.DialContext
+		dialer.LocalAddr = localAddr
+	}
+
+	if err := applyProxy(t, dialer, cfg); err != nil {
+		return nil, err
+	}
+	if t.DialContext == nil {
+		t.DialContext = familyDialContext(dialer, cfg.AddressFamily)
 	}
 
 	return t, nil
 }
 
 // NewThroughputTransport creates a shared HTTP transport optimized for throughput
-// testing. Uses connection pooling, large TCP/HTTP buffers, and optional interface binding.
-func NewThroughputTransport(ifaceName string, maxConns int) (*http.Transport, error) {
+// testing. Uses connection pooling, large TCP/HTTP buffers, optional interface
+// binding, and optional proxying per cfg.Proxy.
+func NewThroughputTransport(cfg Config, maxConns int) (*http.Transport, error) {
 	t := &http.Transport{
 		ForceAttemptHTTP2:   false,
 		MaxIdleConns:        maxConns + 4,
@@ -55,22 +72,75 @@ func NewThroughputTransport(ifaceName string, maxConns int) (*http.Transport, er
 	// Set large TCP socket buffers for high-BDP links (e.g. Starlink ~1 MB BDP)
 	dialer.Control = setSocketBuffers
 
-	if ifaceName != "" {
-		localAddr, err := ResolveInterfaceAddr(ifaceName)
+	if cfg.Interface != "" {
+		localAddr, err := ResolveInterfaceAddrFamily(cfg.Interface, cfg.AddressFamily)
 		if err != nil {
 			return nil, err
 		}
 		dialer.LocalAddr = localAddr
 	}
 
-	t.DialContext = dialer.DialContext
+	if err := applyProxy(t, dialer, cfg); err != nil {
+		return nil, err
+	}
+	if t.DialContext == nil {
+		t.DialContext = familyDialContext(dialer, cfg.AddressFamily)
+	}
 	return t, nil
 }
 
-// NewClient creates an HTTP client bound to the configured interface (if any).
-// Used for metadata and latency phases which share a single client.
+// applyProxy wires cfg.Proxy (or, if unset, the standard proxy env vars) onto
+// t and dialer. HTTP(S) proxies are handled by http.Transport.Proxy, which
+// dials the proxy itself with dialer (so -interface binding still applies);
+// SOCKS5 proxies need a proxy.ContextDialer as t.DialContext instead, built
+// from dialer so the SOCKS5 TCP connection itself stays bound to the
+// interface.
+func applyProxy(t *http.Transport, dialer *net.Dialer, cfg Config) error {
+	if cfg.Proxy == "" {
+		t.Proxy = http.ProxyFromEnvironment
+		if allProxy := os.Getenv("ALL_PROXY"); allProxy != "" {
+			return applyProxyURL(t, dialer, allProxy)
+		}
+		return nil
+	}
+
+	if dialer.LocalAddr != nil && strings.HasPrefix(cfg.Proxy, "https://") {
+		return fmt.Errorf("proxy: https:// proxy scheme cannot be combined with -interface binding; use http:// or socks5://")
+	}
+
+	return applyProxyURL(t, dialer, cfg.Proxy)
+}
+
+func applyProxyURL(t *http.Transport, dialer *net.Dialer, rawProxyURL string) error {
+	u, err := url.Parse(rawProxyURL)
+	if err != nil {
+		return fmt.Errorf("proxy: parse %q: %w", rawProxyURL, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		t.Proxy = http.ProxyURL(u)
+	case "socks5":
+		d, err := proxy.FromURL(u, dialer)
+		if err != nil {
+			return fmt.Errorf("proxy: socks5 dialer: %w", err)
+		}
+		ctxDialer, ok := d.(proxy.ContextDialer)
+		if !ok {
+			return fmt.Errorf("proxy: socks5 dialer does not support context dialing")
+		}
+		t.DialContext = ctxDialer.DialContext
+	default:
+		return fmt.Errorf("proxy: unsupported scheme %q (want http, https, or socks5)", u.Scheme)
+	}
+	return nil
+}
+
+// NewClient creates an HTTP client bound to the configured interface and/or
+// proxy (if any). Used for metadata and latency phases which share a single
+// client.
 func NewClient(cfg Config, timeout time.Duration) (*http.Client, error) {
-	t, err := NewTransport(cfg.Interface)
+	t, err := NewTransport(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -80,6 +150,19 @@ func NewClient(cfg Config, timeout time.Duration) (*http.Client, error) {
 	}, nil
 }
 
+// NewWorkerClient creates a lightweight HTTP client bound to ifaceName (if
+// set), for auxiliary per-worker traffic like the loaded-latency probe that
+// runs alongside a throughput measurement — kept off the shared throughput
+// transport so its requests aren't queued behind the bulk transfer's
+// connections.
+func NewWorkerClient(timeout time.Duration, ifaceName string) (*http.Client, error) {
+	t, err := NewTransport(Config{Interface: ifaceName})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Timeout: timeout, Transport: t}, nil
+}
+
 // ResolveInterfaceAddr finds the first IPv4 address on the named interface
 // and returns a TCP address suitable for net.Dialer.LocalAddr.
 func ResolveInterfaceAddr(name string) (*net.TCPAddr, error) {
@@ -109,3 +192,77 @@ func ResolveInterfaceAddr(name string) (*net.TCPAddr, error) {
 
 	return nil, fmt.Errorf("interface %q has no IPv4 address", name)
 }
+
+// ResolveInterfaceAddrFamily finds the first address of the requested family
+// ("v4" or "v6"; anything else, including "auto" and "", behaves like
+// ResolveInterfaceAddr and picks IPv4) on the named interface.
+func ResolveInterfaceAddrFamily(name, family string) (*net.TCPAddr, error) {
+	if family != "v6" {
+		return ResolveInterfaceAddr(name)
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q: %w", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %q addrs: %w", name, err)
+	}
+
+	for _, addr := range addrs {
+		var ip net.IP
+		switch v := addr.(type) {
+		case *net.IPNet:
+			ip = v.IP
+		case *net.IPAddr:
+			ip = v.IP
+		}
+		if ip == nil || ip.To4() != nil {
+			continue // skip IPv4 and nil
+		}
+		return &net.TCPAddr{IP: ip}, nil
+	}
+
+	return nil, fmt.Errorf("interface %q has no IPv6 address", name)
+}
+
+// familyDialContext wraps dialer.DialContext to force the "tcp4" or "tcp6"
+// network when family requests a single address family; other values dial
+// the usual dual-stack-aware "tcp".
+func familyDialContext(dialer *net.Dialer, family string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	network := "tcp"
+	switch family {
+	case "v4":
+		network = "tcp4"
+	case "v6":
+		network = "tcp6"
+	}
+	if network == "tcp" {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// HasFamilyAddr reports whether host resolves to at least one address in the
+// given family ("v4" or "v6"). Any other value, including "auto" and "",
+// matches everything, since no family restriction is in effect.
+func HasFamilyAddr(host, family string) bool {
+	if family != "v4" && family != "v6" {
+		return true
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+	wantV6 := family == "v6"
+	for _, ip := range ips {
+		if (ip.To4() == nil) == wantV6 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package speedtest
+
+import "time"
+
+// Config bundles the network-level knobs shared by NewClient and the
+// throughput transport constructors: which interface to bind to, which
+// proxy (if any) to route through, and which address family to force.
+type Config struct {
+	// Interface binds outgoing connections to the named network interface's
+	// address (e.g. "eth4"). Empty uses the default route.
+	Interface string
+
+	// Proxy is a socks5://, http://, or https:// URL test traffic should be
+	// routed through. Empty honors the standard proxy environment variables
+	// (HTTPS_PROXY, ALL_PROXY) instead.
+	Proxy string
+
+	// AddressFamily forces "v4" or "v6" dialing. Any other value, including
+	// "auto" and "", lets the OS pick.
+	AddressFamily string
+}
+
+// Metadata describes the client/server context a Result was measured under,
+// for display and for diagnosing which path was actually tested.
+type Metadata struct {
+	Colo       string `json:"colo"`
+	ServerHost string `json:"serverHost"`
+	IP         string `json:"ip,omitempty"`
+	Country    string `json:"country,omitempty"`
+}
+
+// LatencyResult holds unloaded (idle-link) RTT and jitter against a single
+// server, as measured by MeasureLatency before any throughput load is
+// applied.
+type LatencyResult struct {
+	UnloadedMs float64 `json:"unloadedMs"`
+	JitterMs   float64 `json:"jitterMs"`
+}
+
+// ThroughputResult holds the throughput, loaded-latency, and per-request
+// timing statistics from one MeasureThroughput run. DownBps/UpBps are each
+// direction's independent mean rate; Bps is their combined total (equal to
+// DownBps or UpBps alone for a single-direction run).
+type ThroughputResult struct {
+	Bps     float64 `json:"bps"`
+	DownBps float64 `json:"downBps,omitempty"`
+	UpBps   float64 `json:"upBps,omitempty"`
+	Bytes   int64   `json:"bytes"`
+
+	StreamsUsed int `json:"streamsUsed,omitempty"`
+
+	// LoadedLatencyMs/LoadedJitterMs/LoadedP95Ms/RPM summarize the latency
+	// probe samples collected concurrently with the throughput traffic,
+	// i.e. responsiveness under load rather than MeasureLatency's idle RTT.
+	LoadedLatencyMs float64 `json:"loadedLatencyMs,omitempty"`
+	LoadedJitterMs  float64 `json:"loadedJitterMs,omitempty"`
+	LoadedP95Ms     float64 `json:"loadedP95Ms,omitempty"`
+	RPM             float64 `json:"rpm,omitempty"`
+
+	UploadTimes   DurationStats `json:"uploadTimes,omitempty"`
+	DownloadTimes DurationStats `json:"downloadTimes,omitempty"`
+	DownloadTTFB  DurationStats `json:"downloadTtfb,omitempty"`
+}
+
+// Result is the top-level JSON shape main emits: either a single-family run,
+// or (AddressFamily "dual") a wrapper around one IPv4 and one IPv6 Result.
+type Result struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+
+	Timestamp time.Time `json:"timestamp"`
+
+	Metadata *Metadata      `json:"metadata,omitempty"`
+	Latency  *LatencyResult `json:"latency,omitempty"`
+	Probe    *ProbeResult   `json:"probe,omitempty"`
+
+	Download      *ThroughputResult `json:"download,omitempty"`
+	Upload        *ThroughputResult `json:"upload,omitempty"`
+	Bidirectional *ThroughputResult `json:"bidirectional,omitempty"`
+	Mesh          *MeshResult       `json:"mesh,omitempty"`
+
+	// RPM is the overall responsiveness score for this result: the lower
+	// (worse) of the download/upload phase RPMs, or a single phase's RPM
+	// when only one ran.
+	RPM             float64 `json:"rpm,omitempty"`
+	Streams         int     `json:"streams,omitempty"`
+	DurationSeconds int     `json:"durationSeconds,omitempty"`
+
+	// IPv4/IPv6 hold the per-family results for a "dual" AddressFamily run;
+	// nil for a single-family run.
+	IPv4 *Result `json:"ipv4,omitempty"`
+	IPv6 *Result `json:"ipv6,omitempty"`
+}
@@ -0,0 +1,152 @@
+package ookla
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
+)
+
+// serverEntry mirrors the fields speedtest.net's servers?engine=js endpoint
+// returns. lat/lon arrive as JSON strings, not numbers.
+type serverEntry struct {
+	URL     string `json:"url"`
+	Lat     string `json:"lat"`
+	Lon     string `json:"lon"`
+	Name    string `json:"name"` // city
+	Country string `json:"country"`
+	CC      string `json:"cc"`
+	Sponsor string `json:"sponsor"`
+}
+
+// DiscoverServers fetches the public speedtest.net server list.
+func DiscoverServers(ctx context.Context, client *http.Client) ([]Server, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serversURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create servers request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch servers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("servers endpoint returned HTTP %d", resp.StatusCode)
+	}
+
+	var entries []serverEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decode servers: %w", err)
+	}
+
+	servers := make([]Server, 0, len(entries))
+	for _, e := range entries {
+		lat, err := strconv.ParseFloat(e.Lat, 64)
+		if err != nil {
+			continue // skip entries with unparseable coordinates
+		}
+		lon, err := strconv.ParseFloat(e.Lon, 64)
+		if err != nil {
+			continue
+		}
+		servers = append(servers, Server{
+			URL:         e.URL,
+			Provider:    e.Sponsor,
+			City:        e.Name,
+			Country:     e.Country,
+			CountryCode: e.CC,
+			Lat:         lat,
+			Lon:         lon,
+		})
+	}
+
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no usable servers in speedtest.net response")
+	}
+	return servers, nil
+}
+
+// SelectServers sorts candidates by haversine distance to the client and
+// pings the nearest ones, returning the best count by RTT. Unlike
+// uwn.SelectServers, speedtest.net endpoints don't expose enough signal for
+// jitter/handshake-weighted scoring, so this stays a simple two-stage sort.
+// When family is "v4" or "v6", candidates without a resolvable address in
+// that family are dropped before ranking.
+func SelectServers(ctx context.Context, client *http.Client, token string, candidates []Server, count int, clientLat, clientLon float64, family string) ([]Server, error) {
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no candidate servers")
+	}
+
+	candidates = filterByFamily(candidates, family)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no candidate servers reachable over IP%s", family)
+	}
+
+	if clientLat != 0 || clientLon != 0 {
+		sort.Slice(candidates, func(i, j int) bool {
+			di := haversine(clientLat, clientLon, candidates[i].Lat, candidates[i].Lon)
+			dj := haversine(clientLat, clientLon, candidates[j].Lat, candidates[j].Lon)
+			return di < dj
+		})
+	}
+
+	pingCount := count + 2
+	if pingCount < 10 {
+		pingCount = 10
+	}
+	if pingCount > len(candidates) {
+		pingCount = len(candidates)
+	}
+
+	var pinged []Server
+	for i := 0; i < pingCount; i++ {
+		s := candidates[i]
+		latency, err := pingServer(ctx, client, s.URL)
+		if err != nil {
+			continue // skip unreachable servers
+		}
+		s.LatencyMs = latency
+		pinged = append(pinged, s)
+	}
+
+	if len(pinged) == 0 {
+		return nil, fmt.Errorf("no servers responded to ping")
+	}
+
+	sort.Slice(pinged, func(i, j int) bool {
+		return pinged[i].LatencyMs < pinged[j].LatencyMs
+	})
+
+	if count > len(pinged) {
+		count = len(pinged)
+	}
+	return pinged[:count], nil
+}
+
+// filterByFamily drops candidates whose host has no resolvable address in
+// family ("v4" or "v6"); any other value is a no-op.
+func filterByFamily(candidates []Server, family string) []Server {
+	if family != "v4" && family != "v6" {
+		return candidates
+	}
+
+	kept := make([]Server, 0, len(candidates))
+	for _, s := range candidates {
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			continue
+		}
+		if speedtest.HasFamilyAddr(u.Hostname(), family) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
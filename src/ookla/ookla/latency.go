@@ -0,0 +1,136 @@
+package ookla
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
+)
+
+// MeasureLatency performs sequential pings to the server's hello.php endpoint
+// to measure unloaded latency and jitter. token is unused (Ookla servers
+// don't require one) but kept to satisfy speedtest.Provider.
+func MeasureLatency(ctx context.Context, client *http.Client, server Server, token string) (*speedtest.LatencyResult, error) {
+	helloURL := helloEndpoint(server.URL)
+	var latencies []float64
+
+	for i := 0; i < 20; i++ {
+		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		req, err := http.NewRequestWithContext(pingCtx, http.MethodGet, helloURL, nil)
+		if err != nil {
+			cancel()
+			continue
+		}
+		req.Header.Set("User-Agent", userAgent)
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		elapsed := time.Since(start).Seconds() * 1000
+		cancel()
+		if err != nil {
+			continue // skip failed pings instead of aborting
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if elapsed > 0 {
+			latencies = append(latencies, elapsed)
+		}
+	}
+
+	if len(latencies) == 0 {
+		return nil, fmt.Errorf("all latency pings to %s failed", helloURL)
+	}
+
+	sort.Float64s(latencies)
+
+	n := len(latencies)
+	var median float64
+	if n%2 == 0 {
+		median = (latencies[n/2-1] + latencies[n/2]) / 2.0
+	} else {
+		median = latencies[n/2]
+	}
+
+	var jitter float64
+	if n >= 2 {
+		var sum float64
+		for i := 1; i < n; i++ {
+			sum += math.Abs(latencies[i] - latencies[i-1])
+		}
+		jitter = sum / float64(n-1)
+	}
+
+	return &speedtest.LatencyResult{
+		UnloadedMs: math.Round(median*10) / 10,
+		JitterMs:   math.Round(jitter*10) / 10,
+	}, nil
+}
+
+// pingServer returns the minimum RTT to a server's hello.php endpoint over a
+// few attempts, used during server selection.
+func pingServer(ctx context.Context, client *http.Client, serverURL string) (float64, error) {
+	helloURL := helloEndpoint(serverURL)
+
+	var minRTT float64 = math.MaxFloat64
+	for i := 0; i < pingAttempts; i++ {
+		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		req, err := http.NewRequestWithContext(pingCtx, http.MethodGet, helloURL, nil)
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+		req.Header.Set("User-Agent", userAgent)
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		rtt := time.Since(start).Seconds() * 1000
+		cancel()
+		if err != nil {
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if rtt < minRTT {
+			minRTT = rtt
+		}
+	}
+
+	if minRTT == math.MaxFloat64 {
+		return 0, fmt.Errorf("all pings failed")
+	}
+	return minRTT, nil
+}
+
+// helloEndpoint derives the hello.php URL from a server's advertised
+// upload.php URL (e.g. ".../speedtest/upload.php" -> ".../speedtest/hello.php").
+func helloEndpoint(serverURL string) string {
+	if strings.HasSuffix(serverURL, "upload.php") {
+		return strings.TrimSuffix(serverURL, "upload.php") + "hello.php"
+	}
+	return strings.TrimRight(serverURL, "/") + "/hello.php"
+}
+
+// haversine computes the great-circle distance in km between two points.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+	lat1Rad := lat1 * math.Pi / 180
+	lat2Rad := lat2 * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1Rad)*math.Cos(lat2Rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+	return earthRadiusKm * c
+}
@@ -0,0 +1,42 @@
+package ookla
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
+)
+
+// Provider implements speedtest.Provider against speedtest.net.
+type Provider struct{}
+
+func (Provider) Name() string { return "ookla" }
+
+// FetchToken is a no-op: speedtest.net's upload/download/hello endpoints
+// don't require a session token.
+func (Provider) FetchToken(ctx context.Context, client *http.Client) (string, error) {
+	return "", nil
+}
+
+func (Provider) DiscoverServers(ctx context.Context, client *http.Client) ([]Server, error) {
+	return DiscoverServers(ctx, client)
+}
+
+func (Provider) SelectServers(ctx context.Context, client *http.Client, token string, candidates []Server, count int, clientLat, clientLon float64, family, ifaceName string) ([]Server, error) {
+	return SelectServers(ctx, client, token, candidates, count, clientLat, clientLon, family)
+}
+
+func (Provider) MeasureLatency(ctx context.Context, client *http.Client, server Server, token string) (*speedtest.LatencyResult, error) {
+	return MeasureLatency(ctx, client, server, token)
+}
+
+// MeasureThroughput ignores autoTune, progress, and maxMbps: speedtest.net's
+// fixed-size download/upload endpoints don't give us a cheap mid-test
+// throughput signal to ramp against or stream out, and offered-load shaping
+// isn't wired up for this backend, so streams is always used as-is and no
+// progress samples are emitted.
+func (Provider) MeasureThroughput(ctx context.Context, direction speedtest.Direction, streams int, duration time.Duration, ifaceName, proxyURL, family string, autoTune bool, progress io.Writer, maxMbps float64, servers []Server, token string) (*speedtest.ThroughputResult, error) {
+	return MeasureThroughput(ctx, direction, streams, duration, ifaceName, proxyURL, family, servers, token)
+}
@@ -0,0 +1,237 @@
+package ookla
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
+)
+
+const (
+	downloadSize = 25_000_000 // bytes requested per GET via ?size=N
+	uploadSize   = 2_000_000  // bytes per POST body
+)
+
+// MeasureThroughput drives concurrent download, upload, or (direction
+// Bidirectional) interleaved download+upload workers against servers'
+// standard download?size=N / upload.php endpoints, distributed round-robin
+// across servers. Reuses the shared throughput transport so Ookla and UWN
+// runs get the same connection pooling and buffer tuning. DownBps/UpBps on
+// the result report each direction's independent mean throughput; for a
+// single-direction run the other is simply 0. Transient request failures
+// back off per speedtest.RetryBackoff; a fatal one (per
+// speedtest.ClassifyError) stops every worker and surfaces a
+// *speedtest.FatalThroughputError instead of limping along. The shared
+// transport's connections are always released via defer regardless of which
+// path returns.
+func MeasureThroughput(ctx context.Context, direction speedtest.Direction, streams int, duration time.Duration, ifaceName, proxyURL, family string, servers []Server, token string) (*speedtest.ThroughputResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, duration+5*time.Second)
+	defer cancel()
+
+	transport, err := speedtest.NewThroughputTransport(speedtest.Config{Interface: ifaceName, Proxy: proxyURL, AddressFamily: family}, streams)
+	if err != nil {
+		return nil, fmt.Errorf("transport: %w", err)
+	}
+	client := &http.Client{Timeout: 60 * time.Second, Transport: transport}
+	defer transport.CloseIdleConnections()
+
+	var downBytes, upBytes atomic.Int64
+	var wg sync.WaitGroup
+	stopCh := make(chan struct{})
+
+	// stopOnce/closeStop let both a fatal worker error and the normal
+	// end-of-measurement paths close stopCh exactly once; fatalCh carries
+	// the typed error back to the caller when the stop was triggered by the
+	// former.
+	var stopOnce sync.Once
+	closeStop := func() { stopOnce.Do(func() { close(stopCh) }) }
+
+	fatalCh := make(chan error, 1)
+	var fatalOnce sync.Once
+	triggerFatal := func(err error) {
+		fatalOnce.Do(func() {
+			fatalCh <- err
+			closeStop()
+		})
+	}
+
+	var uploadPayload []byte
+	if direction == speedtest.Upload || direction == speedtest.Bidirectional {
+		uploadPayload = make([]byte, uploadSize)
+	}
+
+	for w := 0; w < streams; w++ {
+		server := servers[w%len(servers)]
+		isUpload := direction == speedtest.Upload || (direction == speedtest.Bidirectional && w%2 == 1)
+		wg.Add(1)
+		go func(srv Server, isUpload bool) {
+			defer wg.Done()
+			buf := make([]byte, speedtest.ReadBufferSize)
+			attempt := 0
+
+			for {
+				select {
+				case <-stopCh:
+					return
+				case <-ctx.Done():
+					return
+				default:
+				}
+
+				if isUpload {
+					cr := &speedtest.CountingReader{
+						R:       bytes.NewReader(uploadPayload),
+						Counter: &upBytes,
+					}
+					req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadEndpoint(srv.URL), cr)
+					if err != nil {
+						continue
+					}
+					req.Header.Set("User-Agent", userAgent)
+					req.ContentLength = int64(len(uploadPayload))
+
+					resp, err := client.Do(req)
+					if err != nil {
+						if speedtest.ClassifyError(err, 0) == speedtest.ErrFatal {
+							triggerFatal(&speedtest.FatalThroughputError{Reason: "upload request", Err: err})
+							return
+						}
+						time.Sleep(speedtest.RetryBackoff(attempt))
+						attempt++
+						continue
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					if resp.StatusCode != http.StatusOK {
+						if speedtest.ClassifyError(nil, resp.StatusCode) == speedtest.ErrFatal {
+							triggerFatal(&speedtest.FatalThroughputError{Reason: fmt.Sprintf("server returned %d", resp.StatusCode)})
+							return
+						}
+						time.Sleep(speedtest.RetryBackoff(attempt))
+						attempt++
+						continue
+					}
+					attempt = 0
+				} else {
+					req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadEndpoint(srv.URL), nil)
+					if err != nil {
+						continue
+					}
+					req.Header.Set("User-Agent", userAgent)
+
+					resp, err := client.Do(req)
+					if err != nil {
+						if speedtest.ClassifyError(err, 0) == speedtest.ErrFatal {
+							triggerFatal(&speedtest.FatalThroughputError{Reason: "download request", Err: err})
+							return
+						}
+						time.Sleep(speedtest.RetryBackoff(attempt))
+						attempt++
+						continue
+					}
+					if resp.StatusCode != http.StatusOK {
+						resp.Body.Close()
+						if speedtest.ClassifyError(nil, resp.StatusCode) == speedtest.ErrFatal {
+							triggerFatal(&speedtest.FatalThroughputError{Reason: fmt.Sprintf("server returned %d", resp.StatusCode)})
+							return
+						}
+						time.Sleep(speedtest.RetryBackoff(attempt))
+						attempt++
+						continue
+					}
+					for {
+						n, err := resp.Body.Read(buf)
+						if n > 0 {
+							downBytes.Add(int64(n))
+						}
+						if err != nil {
+							break
+						}
+					}
+					resp.Body.Close()
+					attempt = 0
+				}
+			}
+		}(server, isUpload)
+	}
+
+	var mbpsSamples, downMbpsSamples, upMbpsSamples []float64
+	var lastDown, lastUp int64
+	start := time.Now()
+	lastTime := start
+
+	for time.Since(start) < duration {
+		select {
+		case <-ctx.Done():
+			closeStop()
+			wg.Wait()
+			return nil, ctx.Err()
+		case err := <-fatalCh:
+			wg.Wait()
+			return nil, err
+		case <-time.After(speedtest.SampleInterval):
+		}
+
+		now := time.Now()
+		currentDown := downBytes.Load()
+		currentUp := upBytes.Load()
+		intervalSecs := now.Sub(lastTime).Seconds()
+
+		if intervalSecs > 0.01 {
+			downMbps := (float64(currentDown-lastDown) * 8.0 / 1_000_000.0) / intervalSecs
+			upMbps := (float64(currentUp-lastUp) * 8.0 / 1_000_000.0) / intervalSecs
+			mbpsSamples = append(mbpsSamples, downMbps+upMbps)
+			downMbpsSamples = append(downMbpsSamples, downMbps)
+			upMbpsSamples = append(upMbpsSamples, upMbps)
+		}
+
+		lastDown, lastUp = currentDown, currentUp
+		lastTime = now
+	}
+
+	closeStop()
+	wg.Wait()
+
+	finalBytes := downBytes.Load() + upBytes.Load()
+	if len(mbpsSamples) == 0 {
+		return &speedtest.ThroughputResult{Bytes: finalBytes}, nil
+	}
+
+	return &speedtest.ThroughputResult{
+		Bps:     meanOf(mbpsSamples) * 1_000_000.0,
+		DownBps: meanOf(downMbpsSamples) * 1_000_000.0,
+		UpBps:   meanOf(upMbpsSamples) * 1_000_000.0,
+		Bytes:   finalBytes,
+	}, nil
+}
+
+// meanOf returns the arithmetic mean of samples, or 0 for an empty slice.
+func meanOf(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range samples {
+		sum += v
+	}
+	return sum / float64(len(samples))
+}
+
+// uploadEndpoint returns a server's advertised upload.php URL unchanged.
+func uploadEndpoint(serverURL string) string {
+	return serverURL
+}
+
+// downloadEndpoint derives the download?size=N URL from a server's
+// advertised upload.php URL.
+func downloadEndpoint(serverURL string) string {
+	base := strings.TrimSuffix(serverURL, "upload.php")
+	return fmt.Sprintf("%sdownload?size=%d", base, downloadSize)
+}
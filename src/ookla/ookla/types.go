@@ -0,0 +1,21 @@
+// Package ookla implements speedtest.Provider against the Ookla
+// speedtest.net protocol, as a second measurement source independent of
+// UWN's directory service.
+package ookla
+
+import (
+	"time"
+
+	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
+)
+
+// Server is the provider-agnostic speedtest.Server; Ookla's sponsor/name/cc
+// fields are mapped onto Provider/City/CountryCode during discovery.
+type Server = speedtest.Server
+
+const (
+	serversURL   = "https://www.speedtest.net/api/js/servers?engine=js"
+	userAgent    = "uwn-speedtest-ookla-client/1.0"
+	pingAttempts = 3
+	pingTimeout  = 3 * time.Second
+)
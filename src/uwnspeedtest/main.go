@@ -5,15 +5,26 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"math"
 	"net/url"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
+	"github.com/Ozark-Connect/NetworkOptimizer/src/ookla/ookla"
+	"github.com/Ozark-Connect/NetworkOptimizer/src/uwnspeedtest/probe"
 	"github.com/Ozark-Connect/NetworkOptimizer/src/uwnspeedtest/uwn"
 )
 
+// providers maps the -provider flag value to its speedtest.Provider
+// implementation.
+var providers = map[string]speedtest.Provider{
+	"uwn":   uwn.Provider{},
+	"ookla": ookla.Provider{},
+}
+
 var version = "dev"
 
 func main() {
@@ -26,26 +37,70 @@ func main() {
 	showVersion := flag.Bool("version", false, "Print version")
 	serverCount := flag.Int("servers", 1, "Number of servers to use for throughput")
 	startAt := flag.Int64("start-at", 0, "Unix timestamp to start throughput (for synchronized parallel tests)")
+	probeMode := flag.String("probe", "", "Measure true RTT/loss via transport-level probe: icmp|udp (default: off, HTTP ping only)")
+	probeUDPPort := flag.Int("probe-udp-port", 7, "Destination port for -probe udp echo")
+	providerName := flag.String("provider", "uwn", "Speedtest backend to use: uwn|ookla")
+	proxyURL := flag.String("proxy", "", "Proxy for test traffic: socks5://[user:pass@]host:port, http://host:port, or https://host:port (default: honor HTTPS_PROXY/ALL_PROXY env vars)")
+	family := flag.String("family", "auto", "Address family to test: auto|v4|v6|dual (dual runs the full pipeline once per family)")
+	weightDist := flag.Float64("weight-dist", uwn.SelectionWeights.Dist, "Server selection: weight for normalized geo distance")
+	weightRTT := flag.Float64("weight-rtt", uwn.SelectionWeights.RTT, "Server selection: weight for normalized minimum RTT")
+	weightJitter := flag.Float64("weight-jitter", uwn.SelectionWeights.Jitter, "Server selection: weight for normalized RTT jitter")
+	weightHandshake := flag.Float64("weight-handshake", uwn.SelectionWeights.Handshake, "Server selection: weight for normalized TCP handshake time")
+	autoTune := flag.Bool("autotune", false, "Ramp concurrency up from a small starting point instead of a fixed -streams count, stopping once extra streams stop helping (-streams still caps the ramp)")
+	progress := flag.Bool("progress", false, "Stream JSON-lines progress samples to stderr during throughput phases")
+	meshMode := flag.Bool("mesh", false, "Run a full NxN netperf across the -servers selected servers instead of a single download/upload phase (-provider uwn only)")
+	bidirectional := flag.Bool("bidirectional", false, "Run download and upload concurrently as a single phase instead of sequential phases, to measure how they contend for the same link")
+	maxMbps := flag.Float64("max-mbps", 0, "Cap aggregate offered load to this many Mbps, shared across all streams, instead of measuring at max saturation (0 = unlimited, -provider uwn only)")
 
 	flag.Parse()
 
+	uwn.SelectionWeights.Dist = *weightDist
+	uwn.SelectionWeights.RTT = *weightRTT
+	uwn.SelectionWeights.Jitter = *weightJitter
+	uwn.SelectionWeights.Handshake = *weightHandshake
+
 	if *showVersion {
 		fmt.Println(version)
 		os.Exit(0)
 	}
 
+	provider, ok := providers[*providerName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown -provider %q (want uwn|ookla)\n", *providerName)
+		os.Exit(1)
+	}
+
+	var progressWriter io.Writer
+	if *progress {
+		progressWriter = os.Stderr
+	}
+
 	cfg := uwn.UwnConfig{
-		Streams:      *streams,
-		DurationSecs: *duration,
-		Interface:    *iface,
-		ServerCount:  *serverCount,
-		DownloadOnly: *downloadOnly,
-		UploadOnly:   *uploadOnly,
-		TimeoutSecs:  *timeout,
-		StartAt:      *startAt,
+		Streams:        *streams,
+		DurationSecs:   *duration,
+		Interface:      *iface,
+		ServerCount:    *serverCount,
+		DownloadOnly:   *downloadOnly,
+		UploadOnly:     *uploadOnly,
+		TimeoutSecs:    *timeout,
+		StartAt:        *startAt,
+		ProbeMode:      *probeMode,
+		ProbeUDPPort:   *probeUDPPort,
+		Proxy:          *proxyURL,
+		AddressFamily:  *family,
+		AutoTune:       *autoTune,
+		ProgressWriter: progressWriter,
+		MeshMode:       *meshMode,
+		Bidirectional:  *bidirectional,
+		MaxMbps:        *maxMbps,
 	}
 
-	result := run(cfg)
+	var result speedtest.Result
+	if cfg.AddressFamily == "dual" {
+		result = runDual(provider, cfg)
+	} else {
+		result = run(provider, cfg)
+	}
 
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetIndent("", "  ")
@@ -59,12 +114,32 @@ func main() {
 	}
 }
 
-func run(cfg uwn.UwnConfig) speedtest.Result {
+// runDual drives the full pipeline once bound to the interface's IPv4
+// address and once to its IPv6 address, returning both as nested results.
+func runDual(provider speedtest.Provider, cfg uwn.UwnConfig) speedtest.Result {
+	v4cfg, v6cfg := cfg, cfg
+	v4cfg.AddressFamily = "v4"
+	v6cfg.AddressFamily = "v6"
+
+	fmt.Fprintf(os.Stderr, "=== IPv4 ===\n")
+	v4 := run(provider, v4cfg)
+	fmt.Fprintf(os.Stderr, "=== IPv6 ===\n")
+	v6 := run(provider, v6cfg)
+
+	return speedtest.Result{
+		Timestamp: time.Now().UTC(),
+		Success:   v4.Success || v6.Success,
+		IPv4:      &v4,
+		IPv6:      &v6,
+	}
+}
+
+func run(provider speedtest.Provider, cfg uwn.UwnConfig) speedtest.Result {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.TimeoutSecs)*time.Second)
 	defer cancel()
 
 	// Create client for discovery and latency phases
-	client, err := speedtest.NewClient(speedtest.Config{Interface: cfg.Interface}, 30*time.Second)
+	client, err := speedtest.NewClient(speedtest.Config{Interface: cfg.Interface, Proxy: cfg.Proxy, AddressFamily: cfg.AddressFamily}, 30*time.Second)
 	if err != nil {
 		return errorResult("bind interface: " + err.Error())
 	}
@@ -76,26 +151,32 @@ func run(cfg uwn.UwnConfig) speedtest.Result {
 	if cfg.Interface != "" {
 		fmt.Fprintf(os.Stderr, "Binding to interface %s\n", cfg.Interface)
 	}
+	fmt.Fprintf(os.Stderr, "Using provider: %s\n", provider.Name())
 
-	// Phase 1: Acquire token and IP info
+	// Phase 1: Acquire token (empty for backends like Ookla that need none)
 	fmt.Fprintf(os.Stderr, "Acquiring test token...\n")
-	token, err := uwn.FetchToken(ctx, client)
+	token, err := provider.FetchToken(ctx, client)
 	if err != nil {
 		return errorResult("token: " + err.Error())
 	}
 
-	// Fetch external IP info (non-fatal - used for WAN identification)
+	// Fetch external IP info (non-fatal - used for WAN identification).
+	// This is a UWN directory API; other providers don't expose an
+	// equivalent, so no geo sorting is done and candidates are probed in the
+	// order the provider listed them.
 	var ipInfo *uwn.IpInfo
-	ipInfo, err = uwn.FetchIpInfo(ctx, client)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: could not fetch IP info: %v\n", err)
-	} else {
-		fmt.Fprintf(os.Stderr, "IP: %s (%s)\n", ipInfo.IP, ipInfo.ISP)
+	if provider.Name() == "uwn" {
+		ipInfo, err = uwn.FetchIpInfo(ctx, client)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not fetch IP info: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "IP: %s (%s)\n", ipInfo.IP, ipInfo.ISP)
+		}
 	}
 
 	// Phase 2: Discover and select servers
 	fmt.Fprintf(os.Stderr, "Discovering servers...\n")
-	candidates, err := uwn.DiscoverServers(ctx, client)
+	candidates, err := provider.DiscoverServers(ctx, client)
 	if err != nil {
 		return errorResult("discover: " + err.Error())
 	}
@@ -106,7 +187,7 @@ func run(cfg uwn.UwnConfig) speedtest.Result {
 	if ipInfo != nil {
 		clientLat, clientLon = ipInfo.Lat, ipInfo.Lon
 	}
-	servers, err := uwn.SelectServers(ctx, client, token, candidates, cfg.ServerCount, clientLat, clientLon)
+	servers, err := provider.SelectServers(ctx, client, token, candidates, cfg.ServerCount, clientLat, clientLon, cfg.AddressFamily, cfg.Interface)
 	if err != nil {
 		return errorResult("select servers: " + err.Error())
 	}
@@ -150,13 +231,27 @@ func run(cfg uwn.UwnConfig) speedtest.Result {
 
 	// Phase 3: Unloaded latency (against best server)
 	fmt.Fprintf(os.Stderr, "Measuring latency...\n")
-	latency, err := uwn.MeasureLatency(ctx, servers[0], cfg.Interface)
+	latency, err := provider.MeasureLatency(ctx, client, servers[0], token)
 	if err != nil {
 		return errorResult("latency: " + err.Error())
 	}
 	result.Latency = latency
 	fmt.Fprintf(os.Stderr, "Latency: %.1f ms (jitter: %.1f ms)\n", latency.UnloadedMs, latency.JitterMs)
 
+	// Phase 3b: Optional transport-level probe (ICMP/UDP) for true RTT and loss,
+	// independent of the HTTP /ping path above.
+	if cfg.ProbeMode != "" {
+		fmt.Fprintf(os.Stderr, "Probing %s (%s)...\n", serverHost, cfg.ProbeMode)
+		probeResult, err := runProbe(ctx, cfg, serverHost)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: probe failed: %v\n", err)
+		} else {
+			result.Probe = probeResult
+			fmt.Fprintf(os.Stderr, "Probe: %.1f/%.1f/%.1f ms (min/median/p95), %.1f%% loss\n",
+				probeResult.MinMs, probeResult.MedianMs, probeResult.P95Ms, probeResult.LossPct)
+		}
+	}
+
 	// Synchronized start: wait until the specified time before starting throughput
 	if cfg.StartAt > 0 {
 		startTime := time.Unix(cfg.StartAt, 0)
@@ -172,10 +267,46 @@ func run(cfg uwn.UwnConfig) speedtest.Result {
 		fmt.Fprintf(os.Stderr, "Starting throughput test\n")
 	}
 
+	throughputDuration := time.Duration(cfg.DurationSecs) * time.Second
+
+	// Phase 4/5 (mesh mode): full N×N netperf across the selected servers,
+	// in place of the regular single-target download/upload phases.
+	if cfg.MeshMode {
+		if provider.Name() != "uwn" {
+			return errorResult("mesh mode: only supported with -provider uwn")
+		}
+		fmt.Fprintf(os.Stderr, "Running %dx%d server mesh (%ds per edge)...\n", len(servers), len(servers), cfg.DurationSecs)
+		mesh, err := uwn.MeasureMesh(ctx, cfg, servers, token, throughputDuration)
+		if err != nil {
+			return errorResult("mesh: " + err.Error())
+		}
+		result.Mesh = mesh
+		result.Success = true
+		return result
+	}
+
+	// Phase 4/5 (bidirectional mode): download and upload run concurrently as
+	// a single phase instead of sequentially, so contention between the two
+	// directions shows up in the throughput and loaded-latency numbers.
+	if cfg.Bidirectional {
+		fmt.Fprintf(os.Stderr, "Testing bidirectional (%d streams across %d servers, %ds)...\n", cfg.Streams, len(servers), cfg.DurationSecs)
+		bidi, err := provider.MeasureThroughput(ctx, speedtest.Bidirectional, cfg.Streams, throughputDuration, cfg.Interface, cfg.Proxy, cfg.AddressFamily, cfg.AutoTune, cfg.ProgressWriter, cfg.MaxMbps, servers, token)
+		if err != nil {
+			return errorResult("bidirectional: " + err.Error())
+		}
+		result.Bidirectional = bidi
+		fmt.Fprintf(os.Stderr, "Bidirectional: %.1f Mbps down / %.1f Mbps up\n", bidi.DownBps/1_000_000, bidi.UpBps/1_000_000)
+		result.RPM = bidi.RPM
+		result.Success = true
+		result.Streams = cfg.Streams
+		result.DurationSeconds = cfg.DurationSecs
+		return result
+	}
+
 	// Phase 4: Download
 	if !cfg.UploadOnly {
 		fmt.Fprintf(os.Stderr, "Testing download (%d streams across %d servers, %ds)...\n", cfg.Streams, len(servers), cfg.DurationSecs)
-		dl, err := uwn.MeasureThroughput(ctx, false, cfg, servers, token)
+		dl, err := provider.MeasureThroughput(ctx, speedtest.Download, cfg.Streams, throughputDuration, cfg.Interface, cfg.Proxy, cfg.AddressFamily, cfg.AutoTune, cfg.ProgressWriter, cfg.MaxMbps, servers, token)
 		if err != nil {
 			return errorResult("download: " + err.Error())
 		}
@@ -186,7 +317,7 @@ func run(cfg uwn.UwnConfig) speedtest.Result {
 	// Phase 5: Upload
 	if !cfg.DownloadOnly {
 		fmt.Fprintf(os.Stderr, "Testing upload (%d streams across %d servers, %ds)...\n", cfg.Streams, len(servers), cfg.DurationSecs)
-		ul, err := uwn.MeasureThroughput(ctx, true, cfg, servers, token)
+		ul, err := provider.MeasureThroughput(ctx, speedtest.Upload, cfg.Streams, throughputDuration, cfg.Interface, cfg.Proxy, cfg.AddressFamily, cfg.AutoTune, cfg.ProgressWriter, cfg.MaxMbps, servers, token)
 		if err != nil {
 			return errorResult("upload: " + err.Error())
 		}
@@ -194,6 +325,17 @@ func run(cfg uwn.UwnConfig) speedtest.Result {
 		fmt.Fprintf(os.Stderr, "Upload: %.1f Mbps\n", ul.Bps/1_000_000)
 	}
 
+	// Overall responsiveness is bottlenecked by whichever direction bufferbloats
+	// worse under load, so report the lower of the two phase RPM scores.
+	switch {
+	case result.Download != nil && result.Upload != nil:
+		result.RPM = math.Min(result.Download.RPM, result.Upload.RPM)
+	case result.Download != nil:
+		result.RPM = result.Download.RPM
+	case result.Upload != nil:
+		result.RPM = result.Upload.RPM
+	}
+
 	result.Success = true
 	result.Streams = cfg.Streams
 	result.DurationSeconds = cfg.DurationSecs
@@ -201,6 +343,39 @@ func run(cfg uwn.UwnConfig) speedtest.Result {
 	return result
 }
 
+// runProbe performs a transport-level (ICMP/UDP) probe against host, honoring
+// the -interface binding, and converts the result into the JSON-facing
+// speedtest.ProbeResult shape.
+func runProbe(ctx context.Context, cfg uwn.UwnConfig, host string) (*speedtest.ProbeResult, error) {
+	pcfg := probe.Config{
+		Mode:    probe.Mode(cfg.ProbeMode),
+		UDPPort: cfg.ProbeUDPPort,
+	}
+	if cfg.Interface != "" {
+		addr, err := speedtest.ResolveInterfaceAddr(cfg.Interface)
+		if err != nil {
+			return nil, err
+		}
+		pcfg.LocalAddr = addr.IP
+	}
+
+	r, err := probe.Run(ctx, host, pcfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &speedtest.ProbeResult{
+		Mode:     string(r.Mode),
+		Sent:     r.Sent,
+		Received: r.Received,
+		LossPct:  r.LossPct,
+		MinMs:    r.MinMs,
+		MedianMs: r.MedianMs,
+		P95Ms:    r.P95Ms,
+		MaxMs:    r.MaxMs,
+	}, nil
+}
+
 func errorResult(msg string) speedtest.Result {
 	return speedtest.Result{
 		Success:   false,
@@ -0,0 +1,278 @@
+// Package probe implements transport-level (ICMP/UDP) round-trip and
+// packet-loss measurement, as an alternative to the HTTP /ping RTT used
+// elsewhere in the uwn package. HTTP ping conflates TCP/TLS handshake and
+// server processing time with actual network latency and cannot observe
+// loss; probe talks directly to the network layer instead.
+package probe
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Mode selects the probing method.
+type Mode string
+
+const (
+	ModeICMP Mode = "icmp"
+	ModeUDP  Mode = "udp"
+)
+
+const (
+	defaultCount    = 30
+	defaultSpacing  = 100 * time.Millisecond
+	defaultTimeout  = 1 * time.Second
+	icmpProtoICMP   = 1  // IPv4 ICMP protocol number
+	icmpProtoICMPv6 = 58 // IPv6 ICMP protocol number
+)
+
+// Config controls a single probe run.
+type Config struct {
+	Mode Mode
+
+	// LocalAddr binds outgoing sockets to a specific local address, set
+	// from speedtest.ResolveInterfaceAddr when -interface is given.
+	LocalAddr net.IP
+
+	// UDPPort is the destination port for ModeUDP echo probes.
+	UDPPort int
+
+	Count   int
+	Spacing time.Duration
+	Timeout time.Duration
+}
+
+// Result summarizes RTT and loss for a probe run.
+type Result struct {
+	Mode     Mode    `json:"mode"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	LossPct  float64 `json:"lossPct"`
+	MinMs    float64 `json:"minMs"`
+	MedianMs float64 `json:"medianMs"`
+	P95Ms    float64 `json:"p95Ms"`
+	MaxMs    float64 `json:"maxMs"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.Count == 0 {
+		c.Count = defaultCount
+	}
+	if c.Spacing == 0 {
+		c.Spacing = defaultSpacing
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultTimeout
+	}
+	return c
+}
+
+// Run sends sequenced probe packets to host at a fixed cadence and returns
+// aggregate RTT/loss statistics. host must not include a port for ModeICMP;
+// for ModeUDP it is combined with cfg.UDPPort.
+func Run(ctx context.Context, host string, cfg Config) (*Result, error) {
+	cfg = cfg.withDefaults()
+
+	switch cfg.Mode {
+	case ModeICMP:
+		return runICMP(ctx, host, cfg)
+	case ModeUDP:
+		return runUDP(ctx, host, cfg)
+	default:
+		return nil, fmt.Errorf("probe: unknown mode %q", cfg.Mode)
+	}
+}
+
+func runICMP(ctx context.Context, host string, cfg Config) (*Result, error) {
+	dst, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("probe: resolve %q: %w", host, err)
+	}
+	isV6 := dst.IP.To4() == nil
+
+	// Prefer a privileged raw ICMP socket; fall back to the unprivileged
+	// SOCK_DGRAM/IPPROTO_ICMP path (Linux ping_group_range) which requires
+	// no capabilities but still speaks real ICMP Echo.
+	network := "ip4:icmp"
+	laddr := "0.0.0.0"
+	if isV6 {
+		network = "ip6:ipv6-icmp"
+		laddr = "::"
+	}
+	if cfg.LocalAddr != nil {
+		laddr = cfg.LocalAddr.String()
+	}
+
+	privileged := true
+	conn, err := icmp.ListenPacket(network, laddr)
+	if err != nil {
+		privileged = false
+		udpNetwork := "udp4"
+		if isV6 {
+			udpNetwork = "udp6"
+		}
+		conn, err = icmp.ListenPacket(udpNetwork, laddr)
+		if err != nil {
+			return nil, fmt.Errorf("probe: open icmp socket (tried raw and unprivileged): %w", err)
+		}
+	}
+	defer conn.Close()
+
+	proto := icmpProtoICMP
+	var echoType icmp.Type = ipv4.ICMPTypeEcho
+	if isV6 {
+		proto = icmpProtoICMPv6
+		echoType = ipv6.ICMPTypeEchoRequest
+	}
+
+	// Unprivileged ICMP sockets are addressed like UDP, and the kernel
+	// rewrites the echo ID to the socket's own port, so replies on that path
+	// are matched on sequence number alone.
+	var dstAddr net.Addr = &net.IPAddr{IP: dst.IP}
+	if !privileged {
+		dstAddr = &net.UDPAddr{IP: dst.IP}
+	}
+
+	id := os.Getpid() & 0xffff
+	rtts := make([]float64, 0, cfg.Count)
+	received := 0
+
+	for seq := 1; seq <= cfg.Count; seq++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		msg := icmp.Message{
+			Type: echoType,
+			Code: 0,
+			Body: &icmp.Echo{
+				ID:   id,
+				Seq:  seq,
+				Data: []byte("uwn-probe"),
+			},
+		}
+		wb, err := msg.Marshal(nil)
+		if err != nil {
+			return nil, fmt.Errorf("probe: marshal echo seq %d: %w", seq, err)
+		}
+
+		sent := time.Now()
+		if _, err := conn.WriteTo(wb, dstAddr); err != nil {
+			time.Sleep(cfg.Spacing)
+			continue // treat as loss; keep probing at the fixed cadence
+		}
+
+		conn.SetReadDeadline(sent.Add(cfg.Timeout))
+		rb := make([]byte, 1500)
+		for {
+			n, _, err := conn.ReadFrom(rb)
+			if err != nil {
+				break // timeout: this sequence is lost
+			}
+			rm, err := icmp.ParseMessage(proto, rb[:n])
+			if err != nil {
+				continue
+			}
+			echo, ok := rm.Body.(*icmp.Echo)
+			if !ok || (privileged && echo.ID != id) || echo.Seq != seq {
+				continue // not our reply; keep reading until deadline
+			}
+			rtts = append(rtts, time.Since(sent).Seconds()*1000)
+			received++
+			break
+		}
+
+		time.Sleep(cfg.Spacing)
+	}
+
+	return summarize(cfg.Mode, cfg.Count, received, rtts), nil
+}
+
+func runUDP(ctx context.Context, host string, cfg Config) (*Result, error) {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if cfg.LocalAddr != nil {
+		dialer.LocalAddr = &net.UDPAddr{IP: cfg.LocalAddr}
+	}
+
+	addr := net.JoinHostPort(host, fmt.Sprintf("%d", cfg.UDPPort))
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("probe: dial udp echo %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	rtts := make([]float64, 0, cfg.Count)
+	received := 0
+
+	for seq := 1; seq <= cfg.Count; seq++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		payload := []byte(fmt.Sprintf("uwn-probe:%d", seq))
+		sent := time.Now()
+		if _, err := conn.Write(payload); err != nil {
+			continue
+		}
+
+		conn.SetReadDeadline(sent.Add(cfg.Timeout))
+		rb := make([]byte, 512)
+		n, err := conn.Read(rb)
+		if err == nil && string(rb[:n]) == string(payload) {
+			rtts = append(rtts, time.Since(sent).Seconds()*1000)
+			received++
+		}
+
+		time.Sleep(cfg.Spacing)
+	}
+
+	return summarize(cfg.Mode, cfg.Count, received, rtts), nil
+}
+
+func summarize(mode Mode, sent, received int, rtts []float64) *Result {
+	res := &Result{
+		Mode:     mode,
+		Sent:     sent,
+		Received: received,
+		LossPct:  math.Round((1-float64(received)/float64(sent))*1000) / 10,
+	}
+	if len(rtts) == 0 {
+		return res
+	}
+
+	sort.Float64s(rtts)
+	res.MinMs = rtts[0]
+	res.MaxMs = rtts[len(rtts)-1]
+	res.MedianMs = percentile(rtts, 0.50)
+	res.P95Ms = percentile(rtts, 0.95)
+	return res
+}
+
+// percentile returns the p-th percentile (0..1) of a pre-sorted slice using
+// linear interpolation between the two closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	idx := p * float64(len(sorted)-1)
+	lo := int(math.Floor(idx))
+	hi := int(math.Ceil(idx))
+	if lo == hi {
+		return sorted[lo]
+	}
+	frac := idx - float64(lo)
+	return sorted[lo]*(1-frac) + sorted[hi]*frac
+}
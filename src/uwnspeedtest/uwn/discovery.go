@@ -6,20 +6,44 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"sort"
 	"time"
+
+	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
 )
 
 const (
-	tokenURL     = "https://sp-dir.uwn.com/api/v1/tokens"
-	serversURL   = "https://sp-dir.uwn.com/api/v2/servers"
-	ipInfoURL    = "https://sp-dir.uwn.com/api/v1/ip"
-	userAgent    = "ui-speed-linux-arm64/1.3.4"
-	pingAttempts = 3
-	pingTimeout  = 3 * time.Second // per-ping timeout
+	tokenURL         = "https://sp-dir.uwn.com/api/v1/tokens"
+	serversURL       = "https://sp-dir.uwn.com/api/v2/servers"
+	ipInfoURL        = "https://sp-dir.uwn.com/api/v1/ip"
+	userAgent        = "ui-speed-linux-arm64/1.3.4"
+	pingAttempts     = 3
+	pingTimeout      = 3 * time.Second // per-ping timeout
+	handshakeTimeout = 3 * time.Second
 )
 
+// SelectionWeights controls how SelectServers combines its four scoring
+// components into a single ranking score. Each component is normalized to
+// 0..1 across the probed pool before weighting, so the weights are
+// comparable regardless of their underlying units (km, ms, ms, ms). Lower
+// combined score wins. Overridden by the uwnspeedtest -weight-* flags;
+// defaults favor RTT and jitter over raw distance, since a nearby but
+// congested server is the common failure mode this replaced.
+var SelectionWeights = struct {
+	Dist      float64
+	RTT       float64
+	Jitter    float64
+	Handshake float64
+}{
+	Dist:      0.15,
+	RTT:       0.55,
+	Jitter:    0.20,
+	Handshake: 0.10,
+}
+
 // IpInfo holds the external IP and ISP information from the UWN API.
 type IpInfo struct {
 	IP  string  `json:"ip"`
@@ -109,12 +133,28 @@ func DiscoverServers(ctx context.Context, client *http.Client) ([]Server, error)
 }
 
 // SelectServers sorts candidates by geo distance to estimate proximity,
-// pings all candidates with a short timeout, and returns the best N by RTT.
-func SelectServers(ctx context.Context, client *http.Client, token string, candidates []Server, count int, clientLat, clientLon float64) ([]Server, error) {
+// probes the nearest ones (RTT, RTT jitter, and TCP handshake time), and
+// returns the best N by a weighted combination of all four signals (see
+// SelectionWeights) rather than distance or RTT alone — a nearby but
+// congested server often loses to a slightly farther one with real
+// throughput headroom. Component scores, normalized 0..1 across the probed
+// pool, are stamped onto the returned Servers for JSON debugging. When
+// ifaceName is dual-stacked and a candidate resolves to both an IPv4 and
+// IPv6 address, its URL is rewritten to the literal address of the
+// preferred family (RFC 6724-style destination selection, simplified to
+// "prefer native IPv6 when the interface has it"); this rewrite is skipped
+// when family forces "v4" or "v6". When family is "v4" or "v6", candidates
+// without a resolvable address in that family are dropped before ranking.
+func SelectServers(ctx context.Context, client *http.Client, token string, candidates []Server, count int, clientLat, clientLon float64, family, ifaceName string) ([]Server, error) {
 	if len(candidates) == 0 {
 		return nil, fmt.Errorf("no candidate servers")
 	}
 
+	candidates = filterByFamily(candidates, family)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no candidate servers reachable over IP%s", family)
+	}
+
 	// Sort by geographic distance if we have client coordinates
 	if clientLat != 0 || clientLon != 0 {
 		sort.Slice(candidates, func(i, j int) bool {
@@ -124,52 +164,134 @@ func SelectServers(ctx context.Context, client *http.Client, token string, candi
 		})
 	}
 
-	// Ping nearest candidates by geo distance (at least count+2 to have spares)
-	pingCount := count + 2
-	if pingCount < 10 {
-		pingCount = 10
+	// Probe nearest candidates by geo distance (at least count+2 to have spares)
+	probeCount := count + 2
+	if probeCount < 10 {
+		probeCount = 10
 	}
-	if pingCount > len(candidates) {
-		pingCount = len(candidates)
+	if probeCount > len(candidates) {
+		probeCount = len(candidates)
+	}
+
+	type probed struct {
+		server      Server
+		dist        float64
+		rtt         float64
+		jitter      float64
+		handshakeMs float64
+		handshakeOK bool
 	}
-	var pinged []Server
-	for i := 0; i < pingCount; i++ {
+	var pool []probed
+	for i := 0; i < probeCount; i++ {
 		s := candidates[i]
-		latency, err := pingServer(ctx, client, s.URL, token)
+		rtt, jitter, err := pingServerStats(ctx, client, s.URL, token)
 		if err != nil {
 			continue // skip unreachable servers
 		}
-		s.LatencyMs = latency
-		pinged = append(pinged, s)
+		s.LatencyMs = rtt
+		if family != "v4" && family != "v6" {
+			s = preferAddrFamily(s, ifaceName)
+		}
+
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			continue
+		}
+		handshakeMs, handshakeOK := handshakeProbe(u.Hostname())
+
+		var dist float64
+		if clientLat != 0 || clientLon != 0 {
+			dist = haversine(clientLat, clientLon, s.Lat, s.Lon)
+		}
+		pool = append(pool, probed{server: s, dist: dist, rtt: rtt, jitter: jitter, handshakeMs: handshakeMs, handshakeOK: handshakeOK})
 	}
 
-	if len(pinged) == 0 {
+	if len(pool) == 0 {
 		return nil, fmt.Errorf("no servers responded to ping")
 	}
 
-	// Sort by RTT and return best N
-	sort.Slice(pinged, func(i, j int) bool {
-		return pinged[i].LatencyMs < pinged[j].LatencyMs
-	})
+	// A failed handshake probe has no real time to report, but leaving it at
+	// 0 would normalize to this component's best possible score, actively
+	// pulling dead/slow servers toward the top of selection. Substitute the
+	// worst handshake time actually observed in the pool instead, so a
+	// failed probe penalizes a server at least as much as its slowest
+	// responding peer.
+	var worstHandshake float64
+	haveHandshake := false
+	for _, p := range pool {
+		if p.handshakeOK && (!haveHandshake || p.handshakeMs > worstHandshake) {
+			worstHandshake = p.handshakeMs
+			haveHandshake = true
+		}
+	}
+	for i := range pool {
+		if !pool[i].handshakeOK {
+			pool[i].handshakeMs = worstHandshake
+		}
+	}
+
+	minDist, maxDist := pool[0].dist, pool[0].dist
+	minRTT, maxRTT := pool[0].rtt, pool[0].rtt
+	minJitter, maxJitter := pool[0].jitter, pool[0].jitter
+	minHandshake, maxHandshake := pool[0].handshakeMs, pool[0].handshakeMs
+	for _, p := range pool[1:] {
+		minDist, maxDist = math.Min(minDist, p.dist), math.Max(maxDist, p.dist)
+		minRTT, maxRTT = math.Min(minRTT, p.rtt), math.Max(maxRTT, p.rtt)
+		minJitter, maxJitter = math.Min(minJitter, p.jitter), math.Max(maxJitter, p.jitter)
+		minHandshake, maxHandshake = math.Min(minHandshake, p.handshakeMs), math.Max(maxHandshake, p.handshakeMs)
+	}
+
+	for i := range pool {
+		p := &pool[i]
+		distScore := normalize(p.dist, minDist, maxDist)
+		rttScore := normalize(p.rtt, minRTT, maxRTT)
+		jitterScore := normalize(p.jitter, minJitter, maxJitter)
+		handshakeScore := normalize(p.handshakeMs, minHandshake, maxHandshake)
 
-	if count > len(pinged) {
-		count = len(pinged)
+		p.server.DistScore = distScore
+		p.server.RTTScore = rttScore
+		p.server.JitterScore = jitterScore
+		p.server.HandshakeScore = handshakeScore
+		p.server.JitterMs = p.jitter
+		p.server.HandshakeMs = p.handshakeMs
+		p.server.Score = SelectionWeights.Dist*distScore + SelectionWeights.RTT*rttScore +
+			SelectionWeights.Jitter*jitterScore + SelectionWeights.Handshake*handshakeScore
 	}
-	return pinged[:count], nil
+
+	sort.Slice(pool, func(i, j int) bool { return pool[i].server.Score < pool[j].server.Score })
+
+	if count > len(pool) {
+		count = len(pool)
+	}
+	out := make([]Server, count)
+	for i := 0; i < count; i++ {
+		out[i] = pool[i].server
+	}
+	return out, nil
 }
 
-// pingServer sends a few pings to a server with a short per-request timeout
-// and returns the minimum RTT.
-func pingServer(ctx context.Context, client *http.Client, serverURL, token string) (float64, error) {
+// normalize maps v into 0..1 over [lo, hi]; a degenerate (zero-width) range
+// scores everything 0 rather than dividing by zero.
+func normalize(v, lo, hi float64) float64 {
+	if hi <= lo {
+		return 0
+	}
+	return (v - lo) / (hi - lo)
+}
+
+// pingServerStats sends pingAttempts pings to a server with a short
+// per-request timeout and returns the minimum RTT plus the RTT jitter
+// (standard deviation across the successful attempts).
+func pingServerStats(ctx context.Context, client *http.Client, serverURL, token string) (minRTT, jitter float64, err error) {
 	pingURL := serverURL + "/ping"
 
-	var minRTT float64 = math.MaxFloat64
+	var samples []float64
 	for i := 0; i < pingAttempts; i++ {
 		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
 		req, err := http.NewRequestWithContext(pingCtx, http.MethodGet, pingURL, nil)
 		if err != nil {
 			cancel()
-			return 0, err
+			return 0, 0, err
 		}
 		req.Header.Set("User-Agent", userAgent)
 		req.Header.Set("x-test-token", token)
@@ -187,16 +309,115 @@ func pingServer(ctx context.Context, client *http.Client, serverURL, token strin
 		if resp.StatusCode != http.StatusOK {
 			continue
 		}
+		samples = append(samples, rtt)
+	}
+
+	if len(samples) == 0 {
+		return 0, 0, fmt.Errorf("all pings failed")
+	}
+
+	minRTT = samples[0]
+	var sum float64
+	for _, v := range samples {
+		if v < minRTT {
+			minRTT = v
+		}
+		sum += v
+	}
+	mean := sum / float64(len(samples))
+
+	var variance float64
+	for _, v := range samples {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(samples))
+
+	return minRTT, math.Sqrt(variance), nil
+}
+
+// handshakeProbe times a bare TCP connect to host:443 as a signal for
+// SYN/SYN-ACK latency independent of the server's HTTP stack. ok is false on
+// dial failure or timeout; callers must not treat a failed probe's ms as a
+// real (and therefore best-possible) handshake time — see the caller in
+// SelectServers, which maps it to the pool's worst observed handshake
+// instead.
+func handshakeProbe(host string) (ms float64, ok bool) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, "443"), handshakeTimeout)
+	if err != nil {
+		return 0, false
+	}
+	conn.Close()
+	return time.Since(start).Seconds() * 1000, true
+}
+
+// preferAddrFamily applies a light RFC 6724-style destination address
+// selection: when s's host resolves to both an IPv4 and an IPv6 address and
+// ifaceName has a configured address in both families, s.URL's host is
+// rewritten to the literal address of the preferred family (IPv6, following
+// RFC 6724's preference for native addresses over v4-mapped ones). Servers
+// that resolve to only one family, or interfaces that aren't dual-stacked,
+// are returned unchanged so normal DNS resolution applies.
+func preferAddrFamily(s Server, ifaceName string) Server {
+	if ifaceName == "" {
+		return s
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return s
+	}
 
-		if rtt < minRTT {
-			minRTT = rtt
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return s
+	}
+	var hasV4, hasV6 bool
+	var v6 net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			hasV4 = true
+		} else {
+			hasV6, v6 = true, ip
 		}
 	}
+	if !hasV4 || !hasV6 {
+		return s // only one family on offer
+	}
+
+	if _, err := speedtest.ResolveInterfaceAddrFamily(ifaceName, "v4"); err != nil {
+		return s // interface isn't dual-stacked
+	}
+	if _, err := speedtest.ResolveInterfaceAddrFamily(ifaceName, "v6"); err != nil {
+		return s
+	}
 
-	if minRTT == math.MaxFloat64 {
-		return 0, fmt.Errorf("all pings failed")
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(v6.String(), port)
+	} else {
+		u.Host = "[" + v6.String() + "]"
+	}
+	s.URL = u.String()
+	return s
+}
+
+// filterByFamily drops candidates whose host has no resolvable address in
+// family ("v4" or "v6"); any other value is a no-op.
+func filterByFamily(candidates []Server, family string) []Server {
+	if family != "v4" && family != "v6" {
+		return candidates
+	}
+
+	kept := make([]Server, 0, len(candidates))
+	for _, s := range candidates {
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			continue
+		}
+		if speedtest.HasFamilyAddr(u.Hostname(), family) {
+			kept = append(kept, s)
+		}
 	}
-	return minRTT, nil
+	return kept
 }
 
 // haversine computes the great-circle distance in km between two points.
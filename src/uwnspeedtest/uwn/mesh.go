@@ -0,0 +1,275 @@
+package uwn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
+)
+
+// meshEdgeStreams is the download concurrency used for each edge of the
+// mesh. Kept small relative to MeasureThroughput's normal streams so an edge
+// doesn't saturate the link. Every edge runs for edgeDuration regardless of
+// this value, so a full mesh takes N×N edge durations.
+const meshEdgeStreams = 4
+
+// MeasureMesh runs a full N×N netperf across servers. Since this tool only
+// has a single vantage point (the client machine), a literal server-to-server
+// test isn't possible; row i is this client's download throughput from
+// servers[i], and column j is the loaded latency probed against servers[j]
+// while that download runs. So Mbps[i][j] and LatencyMs[i][j] describe the
+// same edge: "how fast can I pull from server i, and how much does that
+// traffic degrade my path to server j" — off-diagonal latency cells are
+// where cross-server bufferbloat on the client's own link shows up.
+func MeasureMesh(ctx context.Context, cfg UwnConfig, servers []Server, token string, edgeDuration time.Duration) (*speedtest.MeshResult, error) {
+	n := len(servers)
+	if n == 0 {
+		return nil, fmt.Errorf("mesh: no servers selected")
+	}
+
+	mbps := make([][]float64, n)
+	latencyMs := make([][]float64, n)
+	for i := range mbps {
+		mbps[i] = make([]float64, n)
+		latencyMs[i] = make([]float64, n)
+	}
+
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			default:
+			}
+
+			m, lat, err := measureMeshEdge(ctx, cfg, servers[i], servers[j], token, edgeDuration)
+			if err != nil {
+				return nil, fmt.Errorf("mesh edge [%d][%d]: %w", i, j, err)
+			}
+			mbps[i][j] = m
+			latencyMs[i][j] = lat
+		}
+	}
+
+	names := make([]string, n)
+	for i, s := range servers {
+		names[i] = s.City
+	}
+
+	return &speedtest.MeshResult{
+		Servers:   names,
+		Mbps:      mbps,
+		LatencyMs: latencyMs,
+		Summary:   summarizeMesh(mbps, latencyMs),
+	}, nil
+}
+
+// measureMeshEdge downloads from dl with a small fixed worker pool for
+// edgeDuration while concurrently probing latencySrv, returning the mean
+// download throughput (Mbps) and median loaded latency (ms) observed. It's a
+// scaled-down, single-edge version of MeasureThroughput's download path plus
+// its latency probe goroutine, run to completion rather than sampled forever.
+func measureMeshEdge(ctx context.Context, cfg UwnConfig, dl, latencySrv Server, token string, edgeDuration time.Duration) (meanMbps, medianLatencyMs float64, err error) {
+	ctx, cancel := context.WithTimeout(ctx, edgeDuration+5*time.Second)
+	defer cancel()
+
+	transport, err := speedtest.NewThroughputTransport(speedtest.Config{Interface: cfg.Interface, Proxy: cfg.Proxy, AddressFamily: cfg.AddressFamily}, meshEdgeStreams)
+	if err != nil {
+		return 0, 0, fmt.Errorf("transport: %w", err)
+	}
+	client := &http.Client{Timeout: 60 * time.Second, Transport: transport}
+	defer transport.CloseIdleConnections()
+
+	var totalBytes int64
+	bytesCh := make(chan int64, 64)
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+
+	var workers sync.WaitGroup
+	for w := 0; w < meshEdgeStreams; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			buf := make([]byte, speedtest.ReadBufferSize)
+			for {
+				select {
+				case <-stopCh:
+					return
+				case <-ctx.Done():
+					return
+				default:
+				}
+
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, dl.URL+"/download", nil)
+				if err != nil {
+					continue
+				}
+				req.Header.Set("User-Agent", userAgent)
+				req.Header.Set("x-test-token", token)
+
+				resp, err := client.Do(req)
+				if err != nil {
+					select {
+					case <-stopCh:
+						return
+					case <-ctx.Done():
+						return
+					default:
+						time.Sleep(50 * time.Millisecond)
+						continue
+					}
+				}
+				if resp.StatusCode != http.StatusOK {
+					resp.Body.Close()
+					time.Sleep(50 * time.Millisecond)
+					continue
+				}
+
+				for {
+					n, readErr := resp.Body.Read(buf)
+					if n > 0 {
+						select {
+						case bytesCh <- int64(n):
+						case <-stopCh:
+							resp.Body.Close()
+							return
+						}
+					}
+					if readErr != nil {
+						break
+					}
+				}
+				resp.Body.Close()
+			}
+		}()
+	}
+
+	// Latency probe against latencySrv, run concurrently with the downloads
+	// above so its samples reflect the load that edge's download places on
+	// the client's link.
+	var loadedLatencies []float64
+	go func() {
+		defer close(done)
+		probeClient, err := speedtest.NewWorkerClient(10*time.Second, cfg.Interface)
+		if err != nil {
+			return
+		}
+		defer probeClient.CloseIdleConnections()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, latencySrv.URL+"/ping", nil)
+			if err != nil {
+				continue
+			}
+			req.Header.Set("User-Agent", userAgent)
+			req.Header.Set("x-test-token", token)
+
+			start := time.Now()
+			resp, err := probeClient.Do(req)
+			if err != nil {
+				select {
+				case <-stopCh:
+					return
+				case <-ctx.Done():
+					return
+				default:
+					time.Sleep(speedtest.ProbeInterval)
+					continue
+				}
+			}
+			elapsed := time.Since(start).Seconds() * 1000
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			if elapsed > 0 {
+				loadedLatencies = append(loadedLatencies, elapsed)
+			}
+
+			select {
+			case <-stopCh:
+				return
+			case <-ctx.Done():
+				return
+			case <-time.After(speedtest.ProbeInterval):
+			}
+		}
+	}()
+
+	collectDone := make(chan struct{})
+	go func() {
+		defer close(collectDone)
+		for n := range bytesCh {
+			totalBytes += n
+		}
+	}()
+
+	start := time.Now()
+	select {
+	case <-ctx.Done():
+	case <-time.After(edgeDuration):
+	}
+	elapsed := time.Since(start).Seconds()
+
+	close(stopCh)
+	<-done
+	workers.Wait()
+	close(bytesCh)
+	<-collectDone
+
+	if elapsed <= 0 {
+		return 0, 0, nil
+	}
+	meanMbps = (float64(totalBytes) * 8.0 / 1_000_000.0) / elapsed
+	medianLatencyMs, _ = speedtest.ComputeLatencyStats(loadedLatencies)
+	return meanMbps, medianLatencyMs, nil
+}
+
+// summarizeMesh reduces a mesh's Mbps/latency matrices to the headline
+// figures most likely to flag a problem server: mean and minimum throughput
+// across all edges, and the single worst loaded latency observed anywhere in
+// the matrix.
+func summarizeMesh(mbps, latencyMs [][]float64) speedtest.MeshSummary {
+	var sum, min, maxLatency float64
+	min = math.MaxFloat64
+	var count int
+
+	for i := range mbps {
+		for j := range mbps[i] {
+			v := mbps[i][j]
+			sum += v
+			count++
+			if v < min {
+				min = v
+			}
+			if lat := latencyMs[i][j]; lat > maxLatency {
+				maxLatency = lat
+			}
+		}
+	}
+
+	if count == 0 {
+		return speedtest.MeshSummary{}
+	}
+	if min == math.MaxFloat64 {
+		min = 0
+	}
+
+	return speedtest.MeshSummary{
+		MeanMbps:     sum / float64(count),
+		MinMbps:      min,
+		MaxLatencyMs: maxLatency,
+	}
+}
@@ -0,0 +1,47 @@
+package uwn
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
+)
+
+// Provider implements speedtest.Provider against the UWN directory service,
+// delegating to the package-level functions above.
+type Provider struct{}
+
+func (Provider) Name() string { return "uwn" }
+
+func (Provider) FetchToken(ctx context.Context, client *http.Client) (string, error) {
+	return FetchToken(ctx, client)
+}
+
+func (Provider) DiscoverServers(ctx context.Context, client *http.Client) ([]Server, error) {
+	return DiscoverServers(ctx, client)
+}
+
+func (Provider) SelectServers(ctx context.Context, client *http.Client, token string, candidates []Server, count int, clientLat, clientLon float64, family, ifaceName string) ([]Server, error) {
+	return SelectServers(ctx, client, token, candidates, count, clientLat, clientLon, family, ifaceName)
+}
+
+func (Provider) MeasureLatency(ctx context.Context, client *http.Client, server Server, token string) (*speedtest.LatencyResult, error) {
+	return MeasureLatency(ctx, client, server, token)
+}
+
+func (Provider) MeasureThroughput(ctx context.Context, direction speedtest.Direction, streams int, duration time.Duration, ifaceName, proxyURL, family string, autoTune bool, progress io.Writer, maxMbps float64, servers []Server, token string) (*speedtest.ThroughputResult, error) {
+	cfg := UwnConfig{
+		Streams:        streams,
+		DurationSecs:   int(duration.Seconds()),
+		Interface:      ifaceName,
+		Proxy:          proxyURL,
+		AddressFamily:  family,
+		ServerCount:    len(servers),
+		AutoTune:       autoTune,
+		ProgressWriter: progress,
+		MaxMbps:        maxMbps,
+	}
+	return MeasureThroughput(ctx, direction, cfg, servers, token)
+}
@@ -5,7 +5,10 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,133 +17,289 @@ import (
 )
 
 const (
-	uploadSize    = 2_000_000 // 2 MB per upload request
-	warmupSkip    = 0.10       // Skip first 10% of samples
+	uploadSize = 2_000_000 // 2 MB per upload request
+	warmupSkip = 0.10      // Skip first 10% of samples
+
+	rampStartStreams = 2               // initial concurrency for -autotune
+	rampInterval     = 2 * time.Second // how often ramp doubles concurrency
+	rampMinGainFrac  = 0.05            // stop ramping once doubling buys <5% more throughput
+	rampMaxErrFrac   = 0.10            // stop ramping if >10% of requests are erroring
+
+	maxConsecutiveUnauthorized = 3 // consecutive 401s on x-test-token before giving up
 )
 
-// MeasureThroughput runs concurrent download or upload workers distributed
-// round-robin across the selected servers. Uses a shared HTTP transport with
-// connection pooling and large TCP buffers for high-BDP links.
-func MeasureThroughput(ctx context.Context, isUpload bool, cfg UwnConfig, servers []Server, token string) (*speedtest.ThroughputResult, error) {
+// MeasureThroughput runs concurrent download, upload, or (direction
+// Bidirectional) interleaved download+upload workers distributed round-robin
+// across the selected servers. Uses a shared HTTP transport with connection
+// pooling and large TCP buffers for high-BDP links. When cfg.AutoTune is set,
+// the steady-state measurement is preceded by a ramp phase (see rampStreams)
+// that can itself take a while, so the overall deadline is padded by a
+// worst-case ramp budget on top of cfg.DurationSecs. When cfg.MaxMbps is set,
+// a shared rate limiter throttles every worker's upload writes and download
+// reads to that aggregate rate instead of measuring at max saturation.
+// Transient request failures back off per speedtest.RetryBackoff; a fatal
+// one (per speedtest.ClassifyError, or the server racking up
+// maxConsecutiveUnauthorized 401s) stops every worker and surfaces a
+// *speedtest.FatalThroughputError instead of limping along. The shared
+// transport's connections are always released via defer regardless of which
+// path returns.
+func MeasureThroughput(ctx context.Context, direction speedtest.Direction, cfg UwnConfig, servers []Server, token string) (*speedtest.ThroughputResult, error) {
 	duration := time.Duration(cfg.DurationSecs) * time.Second
-	ctx, cancel := context.WithTimeout(ctx, duration+5*time.Second)
+
+	deadline := duration + 5*time.Second
+	if cfg.AutoTune {
+		deadline += rampBudget(cfg.Streams)
+	}
+	ctx, cancel := context.WithTimeout(ctx, deadline)
 	defer cancel()
 
 	// Shared transport: connection pooling across all workers, large buffers
-	transport, err := speedtest.NewThroughputTransport(cfg.Interface, cfg.Streams)
+	transport, err := speedtest.NewThroughputTransport(speedtest.Config{Interface: cfg.Interface, Proxy: cfg.Proxy, AddressFamily: cfg.AddressFamily}, cfg.Streams)
 	if err != nil {
 		return nil, fmt.Errorf("transport: %w", err)
 	}
 	client := &http.Client{Timeout: 60 * time.Second, Transport: transport}
 	defer transport.CloseIdleConnections()
 
-	var totalBytes atomic.Int64
+	// Shared across every worker so cfg.MaxMbps caps the aggregate offered
+	// load regardless of how many streams are running, rather than each
+	// stream getting its own cap.
+	limiter := speedtest.NewRateLimiter(cfg.MaxMbps)
+
+	var downBytes, upBytes atomic.Int64
 	var activeWorkers atomic.Int32
+	var reqCount, errCount atomic.Int64
 	var wg sync.WaitGroup
 
 	var latencyMu sync.Mutex
 	var loadedLatencies []float64
 
+	var timingMu sync.Mutex
+	var uploadTimes, downloadTimes, downloadTTFB []time.Duration
+
 	// Upload payload (shared across workers, content is irrelevant)
 	var uploadPayload []byte
-	if isUpload {
+	if direction == speedtest.Upload || direction == speedtest.Bidirectional {
 		uploadPayload = make([]byte, uploadSize)
 	}
 
-	stopCh := make(chan struct{})
-
-	// Launch throughput workers, distributed round-robin across servers
-	for w := 0; w < cfg.Streams; w++ {
-		server := servers[w%len(servers)]
-		wg.Add(1)
-		go func(srv Server) {
-			defer wg.Done()
-			activeWorkers.Add(1)
+	// workerIsUpload decides a worker's fixed direction for the run. In
+	// Bidirectional mode, workers alternate so roughly half the pool uploads
+	// while the rest download concurrently, rather than each worker doing
+	// both in turn.
+	workerIsUpload := func(idx int) bool {
+		switch direction {
+		case speedtest.Upload:
+			return true
+		case speedtest.Bidirectional:
+			return idx%2 == 1
+		default:
+			return false
+		}
+	}
 
-			buf := make([]byte, speedtest.ReadBufferSize)
+	stopCh := make(chan struct{})
 
-			for {
-				select {
-				case <-stopCh:
-					return
-				case <-ctx.Done():
-					return
-				default:
-				}
+	// stopOnce/closeStop let both a fatal worker error and the normal
+	// end-of-measurement paths close stopCh exactly once; fatalCh carries
+	// the typed error back to the caller when the stop was triggered by the
+	// former. unauthorizedCount tracks consecutive 401s on x-test-token
+	// across all workers, since a single worker's retries wouldn't notice a
+	// token the server has revoked for the whole run.
+	var stopOnce sync.Once
+	closeStop := func() { stopOnce.Do(func() { close(stopCh) }) }
+
+	fatalCh := make(chan error, 1)
+	var fatalOnce sync.Once
+	triggerFatal := func(err error) {
+		fatalOnce.Do(func() {
+			fatalCh <- err
+			closeStop()
+		})
+	}
 
-				if isUpload {
-					url := srv.URL + "/upload"
-					cr := &speedtest.CountingReader{
-						R:       bytes.NewReader(uploadPayload),
-						Counter: &totalBytes,
-					}
-					req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, cr)
-					if err != nil {
-						continue
-					}
-					req.Header.Set("User-Agent", userAgent)
-					req.Header.Set("x-test-token", token)
-					req.ContentLength = int64(len(uploadPayload))
-
-					resp, err := client.Do(req)
-					if err != nil {
-						select {
-						case <-stopCh:
-							return
-						case <-ctx.Done():
-							return
-						default:
-							time.Sleep(50 * time.Millisecond)
-							continue
+	var unauthorizedCount atomic.Int64
+
+	// spawnWorkers grows the worker pool up to target, distributed
+	// round-robin across servers. Workers already running are left alone;
+	// calling it repeatedly with increasing targets is how -autotune ramps
+	// concurrency without tearing anything down.
+	spawned := 0
+	spawnWorkers := func(target int) {
+		for ; spawned < target; spawned++ {
+			server := servers[spawned%len(servers)]
+			isUpload := workerIsUpload(spawned)
+			wg.Add(1)
+			go func(srv Server, isUpload bool) {
+				defer wg.Done()
+				activeWorkers.Add(1)
+
+				buf := make([]byte, speedtest.ReadBufferSize)
+				attempt := 0
+
+				// onStatus folds a response's status code into the shared
+				// unauthorizedCount streak and reports whether this worker
+				// should give up: either the classifier calls it fatal
+				// outright (e.g. 507), or the x-test-token has racked up
+				// maxConsecutiveUnauthorized 401s across every worker,
+				// meaning the server has revoked it for the whole run.
+				onStatus := func(statusCode int) (fatal bool) {
+					if statusCode == http.StatusUnauthorized {
+						if unauthorizedCount.Add(1) >= maxConsecutiveUnauthorized {
+							triggerFatal(&speedtest.FatalThroughputError{Reason: fmt.Sprintf("server returned %d consecutive 401s on x-test-token", maxConsecutiveUnauthorized)})
+							return true
 						}
+					} else {
+						unauthorizedCount.Store(0)
 					}
-					io.Copy(io.Discard, resp.Body)
-					resp.Body.Close()
-
-					if resp.StatusCode != http.StatusOK {
-						time.Sleep(50 * time.Millisecond)
+					if speedtest.ClassifyError(nil, statusCode) == speedtest.ErrFatal {
+						triggerFatal(&speedtest.FatalThroughputError{Reason: fmt.Sprintf("server returned %d", statusCode)})
+						return true
 					}
-				} else {
-					url := srv.URL + "/download"
-					req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-					if err != nil {
-						continue
+					return false
+				}
+
+				for {
+					select {
+					case <-stopCh:
+						return
+					case <-ctx.Done():
+						return
+					default:
 					}
-					req.Header.Set("User-Agent", userAgent)
-					req.Header.Set("x-test-token", token)
-
-					resp, err := client.Do(req)
-					if err != nil {
-						select {
-						case <-stopCh:
-							return
-						case <-ctx.Done():
-							return
-						default:
-							time.Sleep(50 * time.Millisecond)
+
+					reqCount.Add(1)
+					reqStart := time.Now()
+					if isUpload {
+						url := srv.URL + "/upload"
+						cr := &speedtest.CountingReader{
+							R:       &speedtest.RateLimitedReader{R: bytes.NewReader(uploadPayload), Limiter: limiter, Ctx: ctx},
+							Counter: &upBytes,
+						}
+						req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, cr)
+						if err != nil {
 							continue
 						}
-					}
+						req.Header.Set("User-Agent", userAgent)
+						req.Header.Set("x-test-token", token)
+						req.ContentLength = int64(len(uploadPayload))
 
-					if resp.StatusCode != http.StatusOK {
+						resp, err := client.Do(req)
+						if err != nil {
+							errCount.Add(1)
+							if speedtest.ClassifyError(err, 0) == speedtest.ErrFatal {
+								triggerFatal(&speedtest.FatalThroughputError{Reason: "upload request", Err: err})
+								return
+							}
+							select {
+							case <-stopCh:
+								return
+							case <-ctx.Done():
+								return
+							default:
+								time.Sleep(speedtest.RetryBackoff(attempt))
+								attempt++
+								continue
+							}
+						}
+						io.Copy(io.Discard, resp.Body)
 						resp.Body.Close()
-						time.Sleep(50 * time.Millisecond)
-						continue
-					}
 
-					for {
-						n, err := resp.Body.Read(buf)
-						if n > 0 {
-							totalBytes.Add(int64(n))
+						if resp.StatusCode != http.StatusOK {
+							errCount.Add(1)
+							if onStatus(resp.StatusCode) {
+								return
+							}
+							time.Sleep(speedtest.RetryBackoff(attempt))
+							attempt++
+						} else {
+							unauthorizedCount.Store(0)
+							attempt = 0
+							timingMu.Lock()
+							uploadTimes = append(uploadTimes, time.Since(reqStart))
+							timingMu.Unlock()
 						}
+					} else {
+						url := srv.URL + "/download"
+						req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 						if err != nil {
-							break
+							continue
+						}
+						req.Header.Set("User-Agent", userAgent)
+						req.Header.Set("x-test-token", token)
+
+						resp, err := client.Do(req)
+						if err != nil {
+							errCount.Add(1)
+							if speedtest.ClassifyError(err, 0) == speedtest.ErrFatal {
+								triggerFatal(&speedtest.FatalThroughputError{Reason: "download request", Err: err})
+								return
+							}
+							select {
+							case <-stopCh:
+								return
+							case <-ctx.Done():
+								return
+							default:
+								time.Sleep(speedtest.RetryBackoff(attempt))
+								attempt++
+								continue
+							}
 						}
+
+						if resp.StatusCode != http.StatusOK {
+							errCount.Add(1)
+							resp.Body.Close()
+							if onStatus(resp.StatusCode) {
+								return
+							}
+							time.Sleep(speedtest.RetryBackoff(attempt))
+							attempt++
+							continue
+						}
+
+						var ttfb time.Duration
+						ttfbBody := &speedtest.TTFBReader{R: resp.Body, Start: reqStart, TTFB: &ttfb}
+						limited := &speedtest.RateLimitedReader{R: ttfbBody, Limiter: limiter, Ctx: ctx}
+						for {
+							n, err := limited.Read(buf)
+							if n > 0 {
+								downBytes.Add(int64(n))
+							}
+							if err != nil {
+								break
+							}
+						}
+						resp.Body.Close()
+
+						unauthorizedCount.Store(0)
+						attempt = 0
+						timingMu.Lock()
+						downloadTimes = append(downloadTimes, time.Since(reqStart))
+						if ttfb > 0 {
+							downloadTTFB = append(downloadTTFB, ttfb)
+						}
+						timingMu.Unlock()
 					}
-					resp.Body.Close()
 				}
-			}
-		}(server)
+			}(server, isUpload)
+		}
+	}
+
+	streamCap := cfg.Streams
+	if cfg.AutoTune && streamCap <= 0 {
+		streamCap = runtime.GOMAXPROCS(0)
+	}
+
+	combinedBytes := func() int64 { return downBytes.Load() + upBytes.Load() }
+
+	var streamsUsed int
+	var rampMbps float64
+	if cfg.AutoTune {
+		streamsUsed, rampMbps = rampStreams(ctx, stopCh, spawnWorkers, combinedBytes, &reqCount, &errCount, streamCap)
+	} else {
+		spawnWorkers(streamCap)
+		streamsUsed = streamCap
 	}
 
 	// Launch latency probe (separate client to avoid contention with throughput)
@@ -206,73 +365,264 @@ func MeasureThroughput(ctx context.Context, isUpload bool, cfg UwnConfig, server
 
 	// Brief wait for workers to initialize
 	time.Sleep(100 * time.Millisecond)
-	if activeWorkers.Load() == 0 && cfg.Streams > 0 {
-		close(stopCh)
+	if activeWorkers.Load() == 0 && streamCap > 0 {
+		closeStop()
 		wg.Wait()
 		return nil, fmt.Errorf("no workers could bind to interface %q", cfg.Interface)
 	}
 
-	// Sample throughput at regular intervals
-	var mbpsSamples []float64
-	var lastBytes int64
+	// Sample throughput at regular intervals, tracking download and upload
+	// bytes independently so Bidirectional runs can report each direction's
+	// contention-affected rate alongside the combined total.
+	var mbpsSamples, downMbpsSamples, upMbpsSamples []float64
+	var lastDown, lastUp int64
 	start := time.Now()
 	lastTime := start
 
 	for time.Since(start) < duration {
 		select {
 		case <-ctx.Done():
-			close(stopCh)
+			closeStop()
 			wg.Wait()
 			return nil, ctx.Err()
+		case err := <-fatalCh:
+			wg.Wait()
+			return nil, err
 		case <-time.After(speedtest.SampleInterval):
 		}
 
 		now := time.Now()
-		currentBytes := totalBytes.Load()
-		intervalBytes := currentBytes - lastBytes
+		currentDown := downBytes.Load()
+		currentUp := upBytes.Load()
+		currentBytes := currentDown + currentUp
 		intervalSecs := now.Sub(lastTime).Seconds()
 
+		var mbps float64
 		if intervalSecs > 0.01 {
-			mbps := (float64(intervalBytes) * 8.0 / 1_000_000.0) / intervalSecs
+			downMbps := (float64(currentDown-lastDown) * 8.0 / 1_000_000.0) / intervalSecs
+			upMbps := (float64(currentUp-lastUp) * 8.0 / 1_000_000.0) / intervalSecs
+			mbps = downMbps + upMbps
 			mbpsSamples = append(mbpsSamples, mbps)
+			downMbpsSamples = append(downMbpsSamples, downMbps)
+			upMbpsSamples = append(upMbpsSamples, upMbps)
 		}
 
-		lastBytes = currentBytes
+		if cfg.ProgressWriter != nil {
+			latencyMu.Lock()
+			var rollingLatency float64
+			if n := len(loadedLatencies); n > 0 {
+				rollingLatency = loadedLatencies[n-1]
+			}
+			latencyMu.Unlock()
+
+			speedtest.WriteProgressSample(cfg.ProgressWriter, speedtest.ProgressSample{
+				ElapsedSecs:     now.Sub(start).Seconds(),
+				Mbps:            mbps,
+				CumulativeBytes: currentBytes,
+				ActiveWorkers:   activeWorkers.Load(),
+				LoadedLatencyMs: rollingLatency,
+			})
+		}
+
+		lastDown, lastUp = currentDown, currentUp
 		lastTime = now
 	}
 
-	close(stopCh)
+	closeStop()
 	wg.Wait()
 
-	finalBytes := totalBytes.Load()
+	timingMu.Lock()
+	uploadStats := speedtest.ComputeDurationStats(uploadTimes)
+	downloadStats := speedtest.ComputeDurationStats(downloadTimes)
+	ttfbStats := speedtest.ComputeDurationStats(downloadTTFB)
+	timingMu.Unlock()
+
+	finalBytes := combinedBytes()
 	if len(mbpsSamples) == 0 {
-		return &speedtest.ThroughputResult{Bytes: finalBytes}, nil
+		// A short -duration combined with -autotune can spend the whole
+		// budget ramping and leave nothing for steady-state sampling; fall
+		// back to the ramp's last measured rate rather than reporting 0. The
+		// ramp doesn't track direction, so attribute it to whichever
+		// direction(s) were actually running.
+		bps := rampMbps * 1_000_000.0
+		result := &speedtest.ThroughputResult{
+			Bps:           bps,
+			Bytes:         finalBytes,
+			StreamsUsed:   streamsUsed,
+			UploadTimes:   uploadStats,
+			DownloadTimes: downloadStats,
+			DownloadTTFB:  ttfbStats,
+		}
+		switch direction {
+		case speedtest.Upload:
+			result.UpBps = bps
+		case speedtest.Bidirectional:
+			result.DownBps = bps / 2
+			result.UpBps = bps / 2
+		default:
+			result.DownBps = bps
+		}
+		return result, nil
 	}
 
 	// Skip warmup samples, compute mean of steady-state
 	skipCount := int(float64(len(mbpsSamples)) * warmupSkip)
 	steadySamples := mbpsSamples[skipCount:]
+	steadyDown := downMbpsSamples[skipCount:]
+	steadyUp := upMbpsSamples[skipCount:]
 	if len(steadySamples) == 0 {
 		steadySamples = mbpsSamples
+		steadyDown = downMbpsSamples
+		steadyUp = upMbpsSamples
 	}
 
-	var sum float64
-	for _, v := range steadySamples {
-		sum += v
-	}
-	meanMbps := sum / float64(len(steadySamples))
+	meanMbps := meanOf(steadySamples)
 	bps := meanMbps * 1_000_000.0
+	downBps := meanOf(steadyDown) * 1_000_000.0
+	upBps := meanOf(steadyUp) * 1_000_000.0
 
 	latencyMu.Lock()
 	samples := loadedLatencies
 	latencyMu.Unlock()
 
 	loadedMedian, loadedJitter := speedtest.ComputeLatencyStats(samples)
+	loadedP95 := percentile(samples, 0.95)
 
 	return &speedtest.ThroughputResult{
 		Bps:             bps,
+		DownBps:         downBps,
+		UpBps:           upBps,
 		Bytes:           finalBytes,
 		LoadedLatencyMs: loadedMedian,
 		LoadedJitterMs:  loadedJitter,
+		LoadedP95Ms:     loadedP95,
+		RPM:             responsiveness(samples),
+		StreamsUsed:     streamsUsed,
+		UploadTimes:     uploadStats,
+		DownloadTimes:   downloadStats,
+		DownloadTTFB:    ttfbStats,
 	}, nil
 }
+
+// rampStreams grows the worker pool geometrically via spawn — starting at
+// rampStartStreams and doubling every rampInterval — until cap is reached,
+// the throughput gain from the last doubling falls below rampMinGainFrac, or
+// the request error rate exceeds rampMaxErrFrac. Returns the stream count it
+// settled on and the last throughput sample observed during the ramp (for
+// callers whose own measurement window ends up too short to sample again).
+func rampStreams(ctx context.Context, stopCh <-chan struct{}, spawn func(target int), totalBytes func() int64, reqCount, errCount *atomic.Int64, cap int) (streams int, lastMbps float64) {
+	current := rampStartStreams
+	if current > cap {
+		current = cap
+	}
+	if current < 1 {
+		current = 1
+	}
+	spawn(current)
+
+	lastBytes := totalBytes()
+	lastReq := reqCount.Load()
+	lastErr := errCount.Load()
+	lastTime := time.Now()
+
+	for current < cap {
+		select {
+		case <-ctx.Done():
+			return current, lastMbps
+		case <-stopCh:
+			return current, lastMbps
+		case <-time.After(rampInterval):
+		}
+
+		now := time.Now()
+		bytes := totalBytes()
+		req := reqCount.Load()
+		errs := errCount.Load()
+		intervalSecs := now.Sub(lastTime).Seconds()
+
+		intervalReq := req - lastReq
+		intervalErr := errs - lastErr
+		if intervalReq > 0 && float64(intervalErr)/float64(intervalReq) > rampMaxErrFrac {
+			break // error rate is spiking; more concurrency won't help
+		}
+
+		mbps := (float64(bytes-lastBytes) * 8.0 / 1_000_000.0) / intervalSecs
+		if lastMbps > 0 && (mbps-lastMbps)/lastMbps < rampMinGainFrac {
+			lastMbps = mbps
+			break
+		}
+		lastMbps = mbps
+
+		lastBytes, lastReq, lastErr, lastTime = bytes, req, errs, now
+
+		next := current * 2
+		if next > cap {
+			next = cap
+		}
+		spawn(next)
+		current = next
+	}
+
+	return current, lastMbps
+}
+
+// rampBudget estimates the worst-case wall time rampStreams could take to
+// reach cap (or runtime.GOMAXPROCS(0) if cap is unset), so MeasureThroughput
+// can pad its overall deadline enough that a long ramp isn't cut short
+// before steady-state measurement even begins.
+func rampBudget(cap int) time.Duration {
+	if cap <= 0 {
+		cap = runtime.GOMAXPROCS(0)
+	}
+	var steps int
+	for s := rampStartStreams; s < cap; s *= 2 {
+		steps++
+	}
+	return time.Duration(steps) * rampInterval
+}
+
+// meanOf returns the arithmetic mean of samples, or 0 for an empty slice.
+func meanOf(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range samples {
+		sum += v
+	}
+	return sum / float64(len(samples))
+}
+
+// responsiveness computes an RPM-style (round-trips per minute) score from
+// loaded latency samples, following the common p90-based definition: higher
+// is better, and bufferbloat under load shows up as a low RPM even when
+// unloaded ping looks fine.
+func responsiveness(samples []float64) float64 {
+	p90 := percentile(samples, 0.90)
+	if p90 <= 0 {
+		return 0
+	}
+	return 60000 / p90
+}
+
+// percentile returns the p-th percentile (0..1) of loaded latency samples
+// using linear interpolation between the two closest ranks. Samples need not
+// be pre-sorted.
+func percentile(samples []float64, p float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	idx := p * float64(len(sorted)-1)
+	lo := int(math.Floor(idx))
+	hi := int(math.Ceil(idx))
+	if lo == hi {
+		return sorted[lo]
+	}
+	frac := idx - float64(lo)
+	return sorted[lo]*(1-frac) + sorted[hi]*frac
+}
@@ -1,17 +1,14 @@
 package uwn
 
-// Server represents a UWN speed test server from the discovery API.
-type Server struct {
-	URL      string  `json:"url"`
-	Provider string  `json:"provider"`
-	City     string  `json:"city"`
-	Country  string  `json:"country"`
-	Lat      float64 `json:"lat"`
-	Lon      float64 `json:"lon"`
-
-	// Set after latency probing
-	LatencyMs float64 `json:"-"`
-}
+import (
+	"io"
+
+	"github.com/Ozark-Connect/NetworkOptimizer/src/cfspeedtest/speedtest"
+)
+
+// Server is the provider-agnostic speedtest.Server, aliased here so the rest
+// of this package can keep referring to the shorter, UWN-scoped name.
+type Server = speedtest.Server
 
 // tokenResponse is the JSON response from the token endpoint.
 type tokenResponse struct {
@@ -24,8 +21,48 @@ type UwnConfig struct {
 	Streams      int
 	DurationSecs int
 	Interface    string
+	Proxy        string
 	ServerCount  int
 	DownloadOnly bool
 	UploadOnly   bool
 	TimeoutSecs  int
+
+	// AddressFamily is "auto" (default), "v4", "v6", or "dual". "dual" is
+	// handled by runDual in main, which runs the pipeline twice with this
+	// set to "v4" and "v6" in turn; a single run only ever sees auto/v4/v6.
+	AddressFamily string
+
+	// ProbeMode enables a transport-level RTT/loss probe ("icmp" or "udp")
+	// against the selected server's host, independent of the HTTP /ping
+	// latency measurement. Empty disables probing.
+	ProbeMode    string
+	ProbeUDPPort int
+
+	// AutoTune makes MeasureThroughput ramp concurrency up from a small
+	// starting point instead of launching Streams workers immediately,
+	// settling on however many streams it takes to stop seeing a meaningful
+	// throughput gain. Streams still caps how high it will ramp; when
+	// Streams is 0, the cap is runtime.GOMAXPROCS(0).
+	AutoTune bool
+
+	// ProgressWriter, if set, receives a speedtest.ProgressSample JSON line
+	// from MeasureThroughput at each speedtest.SampleInterval tick. Nil
+	// disables progress streaming.
+	ProgressWriter io.Writer
+
+	// MeshMode runs MeasureMesh's full N×N netperf across the selected
+	// servers instead of the regular single-target download/upload phases.
+	MeshMode bool
+
+	// Bidirectional runs download and upload concurrently as a single phase
+	// (speedtest.Bidirectional) instead of the regular sequential
+	// download-then-upload phases, so bufferbloat from one direction
+	// contending with the other shows up in the result.
+	Bidirectional bool
+
+	// MaxMbps caps the aggregate offered load (megabits/sec, shared across
+	// all streams) via a token-bucket rate limiter, instead of measuring at
+	// max saturation. Useful for sampling loaded latency/bufferbloat at a
+	// chosen fraction of link capacity. Zero (the default) means unlimited.
+	MaxMbps float64
 }